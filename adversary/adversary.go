@@ -0,0 +1,48 @@
+// Package adversary contains example strategies that attack transactions as they
+// enter Thereum's txpool. Register them with Thereum.AddAdversary.
+package adversary
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/evan-forbes/ethlab/thereum"
+)
+
+// signer matches the signer used by Thereum
+var signer = types.NewEIP155Signer(big.NewInt(1))
+
+// sign signs the transaction without touching the account's local nonce, as the
+// nonces used by adversaries come from the AttackEnv
+func sign(acc *thereum.Account, tx *types.Transaction) (*types.Transaction, error) {
+	return types.SignTx(tx, signer, acc.PrivKey)
+}
+
+// succeeded checks that every transaction in the simulation was successful
+func succeeded(sim *thereum.Simulation) bool {
+	for _, receipt := range sim.Receipts {
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			return false
+		}
+	}
+	return true
+}
+
+// profit calculates the change in addr's balance caused by the simulation
+func profit(env *thereum.AttackEnv, sim *thereum.Simulation, addr common.Address) *big.Int {
+	return new(big.Int).Sub(sim.State.GetBalance(addr), env.State().GetBalance(addr))
+}
+
+// worthIt checks if the profit is more than the minimum, where a nil minimum is zero
+func worthIt(profit, min *big.Int) bool {
+	if min == nil {
+		return profit.Sign() > 0
+	}
+	return profit.Cmp(min) > 0
+}
+
+// outbid returns a gas price one wei higher than the victim's
+func outbid(victim *types.Transaction) *big.Int {
+	return new(big.Int).Add(victim.GasPrice(), common.Big1)
+}
@@ -0,0 +1,209 @@
+package adversary
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/evan-forbes/ethlab/thereum"
+	"github.com/matryer/is"
+)
+
+// bountyCode deploys a contract that self destructs, sending its balance to whoever
+// calls it first
+var bountyCode = common.FromHex("0x6133ff6000526002601ef3")
+
+func runThereum(t *testing.T) (*thereum.Thereum, func()) {
+	config := thereum.DefaultConfig()
+	config.Allocation["alice"] = "1000000000000000000000"
+	config.Allocation["bob"] = "1000000000000000000000"
+	config.Allocation["eve"] = "1000000000000000000000"
+	eth, err := thereum.New(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go eth.Run(ctx, wg)
+	return eth, func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func waitForReceipt(eth *thereum.Thereum, hash common.Hash) *types.Receipt {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		receipt, _ := eth.TxReceipt(hash)
+		if receipt != nil {
+			return receipt
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func TestCopycat(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runThereum(t)
+	defer stop()
+	alice, bob, eve := eth.Accounts["alice"], eth.Accounts["bob"], eth.Accounts["eve"]
+
+	bounty := big.NewInt(1000000000000000000)
+	deploy, err := alice.Sign(types.NewContractCreation(alice.Nonce.Uint64(), bounty, 100000, alice.TxOpts.GasPrice, bountyCode))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(deploy))
+	receipt := waitForReceipt(eth, deploy.Hash())
+	is.True(receipt != nil)
+	is.Equal(receipt.Status, types.ReceiptStatusSuccessful)
+
+	eth.AddAdversary(NewCopycat(eve))
+
+	// plain transfers aren't worth copying
+	send, err := bob.CreateSend(alice.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(send))
+	is.True(waitForReceipt(eth, send.Hash()) != nil)
+	is.Equal(len(eth.AttackReports()), 0)
+
+	// claiming the bounty is
+	claim, err := bob.Sign(types.NewTransaction(bob.Nonce.Uint64(), receipt.ContractAddress, new(big.Int), 50000, bob.TxOpts.GasPrice, nil))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(claim))
+	receipt = waitForReceipt(eth, claim.Hash())
+	is.True(receipt != nil)
+	is.Equal(receipt.TransactionIndex, uint(1)) // the copy runs first
+
+	reports := eth.AttackReports()
+	is.Equal(len(reports), 1)
+	is.NoErr(reports[0].Err)
+	is.Equal(reports[0].Victim, claim.Hash())
+	is.True(reports[0].Profit.Sign() > 0)
+	is.True(reports[0].Profit.Cmp(bounty) < 0) // gas was paid
+	is.Equal(eth.AdversaryProfit("copycat"), reports[0].Profit)
+}
+
+// routerCode deploys a contract that acts as both a uniswap style router and its
+// token. Every holder has a balance of 1 token, swaps for tokens do nothing, and swaps
+// of tokens for eth pay the caller 2 eth.
+var routerCode = common.FromHex("0x603a80600b6000396000f3" +
+	"60003560e01c806370a0823114601b57806318cbafe51460265700" +
+	"5b600160005260206000f3" +
+	"5b6000808080671bc16d674ec80000335af15000")
+
+// failing is an adversary whose attacks can't be made
+type failing struct {
+	acc *thereum.Account
+}
+
+func (f *failing) Name() string { return "failing" }
+
+func (f *failing) Attack(env *thereum.AttackEnv, victim *types.Transaction) (*thereum.Attack, error) {
+	if victim.Value().Sign() == 0 {
+		return nil, errors.New("nothing to gain")
+	}
+	// a zero gas price is refused by the txpool
+	tx, err := f.acc.Sign(types.NewTransaction(env.Nonce(f.acc.Address), f.acc.Address, new(big.Int), 21000, new(big.Int), nil))
+	if err != nil {
+		return nil, err
+	}
+	return &thereum.Attack{Front: []*types.Transaction{tx}, Beneficiary: f.acc.Address}, nil
+}
+
+func TestFailedAttacks(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runThereum(t)
+	defer stop()
+	alice, bob, eve := eth.Accounts["alice"], eth.Accounts["bob"], eth.Accounts["eve"]
+	eth.AddAdversary(&failing{acc: eve})
+
+	// failed attacks are reported, and their victims are left alone
+	free, err := alice.Sign(types.NewTransaction(alice.Nonce.Uint64(), bob.Address, new(big.Int), 21000, alice.TxOpts.GasPrice, nil))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(free))
+	is.True(waitForReceipt(eth, free.Hash()) != nil)
+	paid, err := bob.CreateSend(alice.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(paid))
+	is.True(waitForReceipt(eth, paid.Hash()) != nil)
+
+	reports := eth.AttackReports()
+	is.Equal(len(reports), 2)
+	is.Equal(reports[0].Victim, free.Hash())
+	is.Equal(reports[0].Err.Error(), "nothing to gain")
+	is.Equal(reports[1].Victim, paid.Hash())
+	is.True(reports[1].Err != nil)
+	is.Equal(reports[1].Bundle, common.Hash{})
+	is.Equal(eth.AdversaryProfit("failing").Sign(), 0)
+}
+
+func TestSandwich(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runThereum(t)
+	defer stop()
+	alice, bob, eve := eth.Accounts["alice"], eth.Accounts["bob"], eth.Accounts["eve"]
+
+	funds, _ := new(big.Int).SetString("10000000000000000000", 10)
+	deploy, err := alice.Sign(types.NewContractCreation(alice.Nonce.Uint64(), funds, 200000, alice.TxOpts.GasPrice, routerCode))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(deploy))
+	receipt := waitForReceipt(eth, deploy.Hash())
+	is.True(receipt != nil)
+	is.Equal(receipt.Status, types.ReceiptStatusSuccessful)
+	router := receipt.ContractAddress
+
+	sandwich, err := NewSandwich(eve, router, big.NewInt(1000000000000000000))
+	is.NoErr(err)
+	eth.AddAdversary(sandwich)
+	nonce, err := eth.GetNonce(eve.Address)
+	is.NoErr(err)
+
+	path := []common.Address{common.HexToAddress("0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2"), router}
+	data, err := sandwich.abi.Pack("swapExactETHForTokens", new(big.Int), path, bob.Address, big.NewInt(1<<40))
+	is.NoErr(err)
+	swap, err := bob.Sign(types.NewTransaction(bob.Nonce.Uint64(), router, big.NewInt(1000000000000000000), 300000, bob.TxOpts.GasPrice, data))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(swap))
+	receipt = waitForReceipt(eth, swap.Hash())
+	is.True(receipt != nil)
+
+	// the buy runs right before the victim, then the approval and sale right after
+	block, err := eth.BlockByNumber(context.Background(), receipt.BlockNumber)
+	is.NoErr(err)
+	txs := block.Transactions()
+	victim := int(receipt.TransactionIndex)
+	is.True(victim >= 1 && victim+2 < len(txs))
+	attack := []*types.Transaction{txs[victim-1], txs[victim+1], txs[victim+2]}
+	for i, tx := range attack {
+		from, err := types.Sender(signer, tx)
+		is.NoErr(err)
+		is.Equal(from, eve.Address)
+		is.Equal(tx.Nonce(), nonce+uint64(i))
+		is.Equal(*tx.To(), router)
+	}
+	is.Equal(attack[0].Value(), sandwich.Amount)
+	is.Equal(attack[1].Data()[:4], sandwich.abi.Methods["approve"].ID())
+	is.Equal(attack[2].Data()[:4], sandwich.abi.Methods["swapExactTokensForETH"].ID())
+
+	reports := eth.AttackReports()
+	is.Equal(len(reports), 1)
+	is.NoErr(reports[0].Err)
+	is.Equal(reports[0].Victim, swap.Hash())
+	is.True(reports[0].Profit.Sign() > 0)
+}
+
+func TestNewSandwich(t *testing.T) {
+	is := is.New(t)
+	acc, err := thereum.NewAccount("eve", big.NewInt(0))
+	is.NoErr(err)
+	s, err := NewSandwich(acc, common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D"), big.NewInt(1))
+	is.NoErr(err)
+	_, has := s.abi.Methods["swapExactETHForTokens"]
+	is.True(has)
+}
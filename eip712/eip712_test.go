@@ -0,0 +1,126 @@
+package eip712
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/matryer/is"
+)
+
+// mailTypedData is the example used by EIP-712
+const mailTypedData = `{
+	"types": {
+		"EIP712Domain": [
+			{"name": "name", "type": "string"},
+			{"name": "version", "type": "string"},
+			{"name": "chainId", "type": "uint256"},
+			{"name": "verifyingContract", "type": "address"}
+		],
+		"Person": [
+			{"name": "name", "type": "string"},
+			{"name": "wallet", "type": "address"}
+		],
+		"Mail": [
+			{"name": "from", "type": "Person"},
+			{"name": "to", "type": "Person"},
+			{"name": "contents", "type": "string"}
+		]
+	},
+	"primaryType": "Mail",
+	"domain": {
+		"name": "Ether Mail",
+		"version": "1",
+		"chainId": 1,
+		"verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+	},
+	"message": {
+		"from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+		"to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+		"contents": "Hello, Bob!"
+	}
+}`
+
+func TestTypedData(t *testing.T) {
+	is := is.New(t)
+	var data TypedData
+	is.NoErr(json.Unmarshal([]byte(mailTypedData), &data))
+	separator, err := data.DomainSeparator()
+	is.NoErr(err)
+	is.Equal(separator.Hex(), "0xf2cee375fa42b42143804025fc449deafd50cc031ca257e0b194a650a912090f")
+	hash, err := data.Hash()
+	is.NoErr(err)
+	is.Equal(hash.Hex(), "0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2")
+
+	key := crypto.ToECDSAUnsafe(crypto.Keccak256([]byte("cow")))
+	sig, err := Sign(key, &data)
+	is.NoErr(err)
+	is.Equal(hexutil.Encode(sig), "0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b915621c")
+	signer, err := Recover(&data, sig)
+	is.NoErr(err)
+	is.Equal(signer, common.HexToAddress("0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"))
+
+	// structs shaped like abigen's bindings hash the same as json
+	type Person struct {
+		Name   string
+		Wallet common.Address
+	}
+	type Mail struct {
+		From     Person
+		To       Person
+		Contents string
+	}
+	data.Message = Mail{
+		From:     Person{Name: "Cow", Wallet: common.HexToAddress("0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826")},
+		To:       Person{Name: "Bob", Wallet: common.HexToAddress("0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB")},
+		Contents: "Hello, Bob!",
+	}
+	fromStruct, err := data.Hash()
+	is.NoErr(err)
+	is.Equal(fromStruct, hash)
+
+	// arrays of structs, integers and fixed bytes work from json and go values alike
+	type Group struct {
+		Members []Person
+		Ids     []*big.Int
+		Tag     [4]byte
+	}
+	group := &TypedData{
+		Types: TypedDataTypes{
+			"Person": data.Types["Person"],
+			"Group": {
+				{Name: "members", Type: "Person[]"},
+				{Name: "ids", Type: "uint64[]"},
+				{Name: "tag", Type: "bytes4"},
+			},
+		},
+		PrimaryType: "Group",
+		Domain:      data.Domain,
+		Message: Group{
+			Members: []Person{data.Message.(Mail).From, data.Message.(Mail).To},
+			Ids:     []*big.Int{big.NewInt(1), big.NewInt(256)},
+			Tag:     [4]byte{0xde, 0xad, 0xbe, 0xef},
+		},
+	}
+	is.Equal(string(group.EncodeType("Group")), "Group(Person[] members,uint64[] ids,bytes4 tag)Person(string name,address wallet)")
+	fromGo, err := group.Hash()
+	is.NoErr(err)
+	is.NoErr(json.Unmarshal([]byte(`{
+		"members": [
+			{"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+			{"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"}
+		],
+		"ids": ["1", "0x100"],
+		"tag": "0xdeadbeef"
+	}`), &group.Message))
+	fromJSON, err := group.Hash()
+	is.NoErr(err)
+	is.Equal(fromGo, fromJSON)
+
+	group.Message.(map[string]interface{})["tag"] = "0xdead"
+	_, err = group.Hash()
+	is.True(err != nil)
+}
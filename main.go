@@ -7,6 +7,7 @@ import (
 	"github.com/evan-forbes/ethlab/cmd/abigen"
 	"github.com/evan-forbes/ethlab/cmd/boot"
 	"github.com/evan-forbes/ethlab/cmd/compile"
+	"github.com/evan-forbes/ethlab/cmd/replay"
 	cli "github.com/urfave/cli/v2"
 )
 
@@ -88,6 +89,15 @@ func main() {
 		// },
 	}
 
+	// replayFlags are the flags for replay
+	replayFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:  "config, c",
+			Value: "",
+			Usage: "*optional* path to the config file (.json) the recording was made with",
+		},
+	}
+
 	// subcommands
 	app.Commands = []*cli.Command{
 		{
@@ -102,6 +112,13 @@ func main() {
 			Flags:  bootFlags,
 			Action: boot.Boot,
 		},
+		{
+			Name:      "replay",
+			Usage:     "replay recorded json rpc requests on a fresh chain, diffing the responses",
+			ArgsUsage: "<file>",
+			Flags:     replayFlags,
+			Action:    replay.Replay,
+		},
 		{
 			Name:   "compile",
 			Usage:  "combine solc and abigen with a simple naming scheme",
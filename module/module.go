@@ -16,6 +16,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/evan-forbes/ethlab/eip712"
 	"github.com/pkg/errors"
 )
 
@@ -69,7 +70,7 @@ func StarterKit(host string) (*User, error) {
 		return nil, err
 	}
 	user.Client = client
-	err = RequestETH(host, user.From.Hex(), big.NewInt(1000000000000000000))
+	_, err = RequestETH(host, user.From.Hex(), big.NewInt(1000000000000000000))
 	return user, err
 }
 
@@ -113,56 +114,99 @@ func (u *User) Sign(tx *types.Transaction) (*types.Transaction, error) {
 	return types.SignTx(tx, types.NewEIP155Signer(big.NewInt(1)), u.priv)
 }
 
+// SignTypedData signs EIP-712 typed data using the user's private key. The message
+// can be a map, or a struct generated by abigen.
+func (u *User) SignTypedData(data *eip712.TypedData) ([]byte, error) {
+	return eip712.Sign(u.priv, data)
+}
+
+// VerifyTypedData reports if sig is user u's signature of the typed data
+func (u *User) VerifyTypedData(data *eip712.TypedData, sig []byte) (bool, error) {
+	return VerifyTypedData(data, sig, u.From)
+}
+
+// VerifyTypedData reports if sig is the signer's signature of the typed data
+func VerifyTypedData(data *eip712.TypedData, sig []byte, signer common.Address) (bool, error) {
+	recovered, err := eip712.Recover(data, sig)
+	if err != nil {
+		return false, err
+	}
+	return recovered == signer, nil
+}
+
 func (u *User) IncrNonce() {
 	nonce := new(big.Int).Add(u.nonce, big.NewInt(1))
 	u.nonce = nonce
 }
 
-// RequestETH asks the server to dish out some eth to an address
-func RequestETH(host, address string, amount *big.Int) error {
+// FaucetReceipt describes the eth sent by the faucet
+type FaucetReceipt struct {
+	TxHash      common.Hash `json:"tx_hash"`
+	BlockNumber uint64      `json:"block_number"` // BlockNumber is the block the transaction was mined in
+	Balance     *big.Int    `json:"balance"`      // Balance is the recipient's balance after that block
+}
+
+// FaucetError is returned when the faucet refuses a request, or can't send the eth
+type FaucetError struct {
+	Status     int          `json:"-"` // Status is the http status code of the response
+	Message    string       `json:"message"`
+	Reason     string       `json:"reason"`      // Reason is a short name for the error, such as address_cooldown
+	RetryAfter int64        `json:"retry_after"` // RetryAfter is how many seconds to wait before trying again, if waiting helps
+	TxHash     *common.Hash `json:"tx_hash"`     // TxHash is set if the eth was sent, but didn't arrive in time
+}
+
+func (e *FaucetError) Error() string {
+	return fmt.Sprintf("failure to send eth: %s", e.Message)
+}
+
+// RequestETH asks the server to dish out some eth to an address, waiting until it's
+// been mined. Errors from the faucet are returned as a *FaucetError.
+func RequestETH(host, address string, amount *big.Int) (*FaucetReceipt, error) {
 
 	type faucetPay struct {
 		Address string   `json:"address"`
 		Amount  *big.Int `json:"amount"`
 	}
 
-	type faucetResp struct {
-		Message string `json:"message"`
-	}
-
 	data := faucetPay{
 		Address: address,
 		Amount:  amount,
 	}
 	payloadBytes, err := json.Marshal(data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	body := bytes.NewReader(payloadBytes)
 
 	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/requestETH", host), body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	rawResp, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	var out faucetResp
-	json.Unmarshal(rawResp, &out)
-	if out.Message != "success" {
-		return errors.Errorf("failure to send eth: %s", out.Message)
+	if resp.StatusCode != http.StatusOK {
+		out := &FaucetError{Status: resp.StatusCode}
+		if json.Unmarshal(rawResp, out) != nil || out.Message == "" {
+			out.Message = strings.TrimSpace(string(rawResp))
+		}
+		return nil, out
 	}
-
-	return nil
+	var out FaucetReceipt
+	err = json.Unmarshal(rawResp, &out)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse faucet response")
+	}
+	return &out, nil
 }
 
 // ENSAddress asks the host for the hex address of the ens contract
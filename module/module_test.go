@@ -1,10 +1,50 @@
 package module
 
 import (
+	"math/big"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/evan-forbes/ethlab/eip712"
+	"github.com/matryer/is"
 )
 
 // modules run
 func TestModule(t *testing.T) {
 
 }
+
+func TestSignTypedData(t *testing.T) {
+	is := is.New(t)
+	usr, err := NewUser()
+	is.NoErr(err)
+	other, err := NewUser()
+	is.NoErr(err)
+
+	// messages can be structs like the ones abigen generates
+	type Order struct {
+		Maker  common.Address
+		Amount *big.Int
+	}
+	data := &eip712.TypedData{
+		Types: eip712.TypedDataTypes{
+			"Order": {{Name: "maker", Type: "address"}, {Name: "amount", Type: "uint256"}},
+		},
+		PrimaryType: "Order",
+		Domain:      eip712.TypedDataDomain{Name: "ethlab", ChainId: big.NewInt(1)},
+		Message:     Order{Maker: usr.From, Amount: big.NewInt(100)},
+	}
+	sig, err := usr.SignTypedData(data)
+	is.NoErr(err)
+	valid, err := usr.VerifyTypedData(data, sig)
+	is.NoErr(err)
+	is.True(valid)
+	valid, err = other.VerifyTypedData(data, sig)
+	is.NoErr(err)
+	is.True(!valid)
+
+	data.Message = Order{Maker: usr.From, Amount: big.NewInt(101)}
+	valid, err = usr.VerifyTypedData(data, sig)
+	is.NoErr(err)
+	is.True(!valid)
+}
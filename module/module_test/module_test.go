@@ -24,12 +24,12 @@ func TestRequestETH(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	err = module.RequestETH("127.0.0.1:8000", usr.NewTxOpts().From.Hex(), big.NewInt(2000000000000000000))
+	_, err = module.RequestETH("127.0.0.1:8000", usr.NewTxOpts().From.Hex(), big.NewInt(2000000000000000000))
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	err = module.RequestETH("127.0.0.1:8000", usr.NewTxOpts().From.Hex(), big.NewInt(2000000000000000000))
+	_, err = module.RequestETH("127.0.0.1:8000", usr.NewTxOpts().From.Hex(), big.NewInt(2000000000000000000))
 	if err != nil {
 		t.Error(err)
 		return
@@ -118,12 +118,12 @@ func TestSyncNonce(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	err = module.RequestETH("127.0.0.1:8000", usr.From.Hex(), big.NewInt(1000000000000000000))
+	_, err = module.RequestETH("127.0.0.1:8000", usr.From.Hex(), big.NewInt(1000000000000000000))
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	err = module.RequestETH("127.0.0.1:8000", usr.From.Hex(), big.NewInt(1000000000000000000))
+	_, err = module.RequestETH("127.0.0.1:8000", usr.From.Hex(), big.NewInt(1000000000000000000))
 	if err != nil {
 		t.Error(err)
 		return
@@ -151,7 +151,7 @@ func bootWithUser(t *testing.T) (*module.User, *cmd.Manager, error) {
 		t.Error(err)
 		return nil, mngr, err
 	}
-	err = module.RequestETH("127.0.0.1:8000", usr.From.Hex(), big.NewInt(1000000000000000000))
+	_, err = module.RequestETH("127.0.0.1:8000", usr.From.Hex(), big.NewInt(1000000000000000000))
 	if err != nil {
 		t.Error(err)
 		return nil, mngr, err
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/evan-forbes/ethlab/eip712"
+	"github.com/evan-forbes/ethlab/thereum"
+)
+
+////////////////////////////////
+//	Unlocked Accounts
+//////////////////////////////
+
+// getAccounts lists the unlocked accounts the server can sign for
+func getAccounts(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  eth.UnlockedAccounts(),
+	}
+	return out, nil
+}
+
+// sendTx fills in, signs and sends a transaction from an unlocked account
+func sendTx(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":[{"from":"0xb60e8dd61c5d32be8058bb8eb970870f07233155","to":"0xd46e8dd67c5d32be8058bb8eb970870f07244567","value":"0x9184e72a"}]
+	var args thereum.TxArgs
+	err := unmarshalParams(msg, 1, &args)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := eth.SendTxArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  tx.Hash(),
+	}
+	return out, nil
+}
+
+// signTxResult is the signed transaction, both rlp encoded and as json
+type signTxResult struct {
+	Raw hexutil.Bytes      `json:"raw"`
+	Tx  *types.Transaction `json:"tx"`
+}
+
+// signTx fills in and signs a transaction from an unlocked account without sending it
+func signTx(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	var args thereum.TxArgs
+	err := unmarshalParams(msg, 1, &args)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := eth.SignTxArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  signTxResult{Raw: raw, Tx: tx},
+	}
+	return out, nil
+}
+
+// sign signs a message using an unlocked account
+func sign(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0x9b2055d370f73ec7d8a03e965129118dc8f5bf83", "0xdeadbeaf"]
+	var addr common.Address
+	var data hexutil.Bytes
+	err := unmarshalParams(msg, 2, &addr, &data)
+	if err != nil {
+		return nil, err
+	}
+	return signText(eth, addr, data)
+}
+
+// personalSign is sign with the parameters in the opposite order
+func personalSign(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0xdeadbeaf", "0x9b2055d370f73ec7d8a03e965129118dc8f5bf83"]
+	var data hexutil.Bytes
+	var addr common.Address
+	err := unmarshalParams(msg, 2, &data, &addr)
+	if err != nil {
+		return nil, err
+	}
+	return signText(eth, addr, data)
+}
+
+func signText(eth *thereum.Thereum, addr common.Address, data []byte) (*rpcMessage, error) {
+	sig, err := eth.SignText(addr, data)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  hexutil.Bytes(sig),
+	}
+	return out, nil
+}
+
+// signTypedData signs EIP-712 typed data using an unlocked account
+func signTypedData(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0xcd2a3d9f938e13cd947ec05abc7fe734df8dd826", {"types":{...},"primaryType":"Mail","domain":{...},"message":{...}}]
+	var addr common.Address
+	var raw json.RawMessage
+	err := unmarshalParams(msg, 2, &addr, &raw)
+	if err != nil {
+		return nil, err
+	}
+	// wallets commonly send the typed data as a json encoded string
+	var encoded string
+	if json.Unmarshal(raw, &encoded) == nil {
+		raw = json.RawMessage(encoded)
+	}
+	var data eip712.TypedData
+	err = json.Unmarshal(raw, &data)
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+	sig, err := eth.SignTypedData(addr, &data)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  hexutil.Bytes(sig),
+	}
+	return out, nil
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/evan-forbes/ethlab/thereum"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+////////////////////////////////
+//	Origins and Authorization
+//////////////////////////////
+
+// permission is the access needed to use a namespace, and the access a caller has
+type permission int
+
+const (
+	publicPermission permission = iota // anyone can use public namespaces
+	adminPermission                    // callers with a valid bearer token are admins
+)
+
+// parsePermission parses the permissions used in an AuthConfig
+func parsePermission(s string) (permission, error) {
+	switch strings.ToLower(s) {
+	case "public":
+		return publicPermission, nil
+	case "admin":
+		return adminPermission, nil
+	}
+	return publicPermission, fmt.Errorf("unknown permission %q, use public or admin", s)
+}
+
+// policy decides which origins and callers can use the server
+type policy struct {
+	anyOrigin  bool
+	origins    map[string]bool
+	secret     []byte // secret verifies bearer tokens, which aren't accepted if nil
+	namespaces map[string]permission
+	fallback   permission // fallback is the permission of namespaces not listed
+}
+
+// newPolicy parses the auth config. Namespaces can only be restricted to admins if
+// there's a secret to authenticate them with.
+func newPolicy(cfg thereum.AuthConfig) (*policy, error) {
+	p := &policy{
+		anyOrigin:  len(cfg.Origins) == 0,
+		origins:    make(map[string]bool),
+		namespaces: make(map[string]permission),
+	}
+	for _, origin := range cfg.Origins {
+		if origin == "*" {
+			p.anyOrigin = true
+		}
+		p.origins[normalizeOrigin(origin)] = true
+	}
+	if cfg.JWTSecret != "" {
+		p.secret = []byte(cfg.JWTSecret)
+		if strings.HasPrefix(cfg.JWTSecret, "0x") {
+			secret, err := hexutil.Decode(cfg.JWTSecret)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid jwt secret")
+			}
+			p.secret = secret
+		}
+	}
+	restricted := false
+	for namespace, s := range cfg.Namespaces {
+		perm, err := parsePermission(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid permission for namespace %s", namespace)
+		}
+		if namespace == "*" {
+			p.fallback = perm
+		} else {
+			p.namespaces[namespace] = perm
+		}
+		restricted = restricted || perm == adminPermission
+	}
+	if restricted && p.secret == nil {
+		return nil, errors.New("namespaces can only be restricted to admins when a jwt secret is set")
+	}
+	return p, nil
+}
+
+// SetAuth applies the auth config to every transport except ipc, whose callers are
+// always admins. It must be called before the server starts serving.
+func (s *Server) SetAuth(cfg thereum.AuthConfig) error {
+	p, err := newPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	s.policy = p
+	return nil
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimSuffix(origin, "/"))
+}
+
+// allowOrigin reports if a browser can make requests from origin. Requests without an
+// origin don't come from browsers, so they're always allowed.
+func (p *policy) allowOrigin(origin string) bool {
+	return origin == "" || p.anyOrigin || p.origins[normalizeOrigin(origin)]
+}
+
+// allowed reports if a caller with the role can call method, using the method's
+// namespace, such as eth for eth_getBalance
+func (p *policy) allowed(method string, role permission) bool {
+	namespace := method
+	if i := strings.Index(method, "_"); i >= 0 {
+		namespace = method[:i]
+	}
+	return p.allowedNamespace(namespace, role)
+}
+
+func (p *policy) allowedNamespace(namespace string, role permission) bool {
+	perm, has := p.namespaces[namespace]
+	if !has {
+		perm = p.fallback
+	}
+	return role >= perm
+}
+
+// authenticate returns the role of the request's caller, using the bearer token in
+// the Authorization header. Browsers can't set headers when opening websockets, so
+// they can use the token query parameter instead.
+func (p *policy) authenticate(r *http.Request) (permission, error) {
+	var token string
+	if header := r.Header.Get("Authorization"); header != "" {
+		if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+			return publicPermission, errors.New("authorization must use a bearer token")
+		}
+		token = strings.TrimSpace(header[7:])
+	} else if websocket.IsWebSocketUpgrade(r) {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		return publicPermission, nil
+	}
+	if p.secret == nil {
+		return publicPermission, errors.New("bearer tokens aren't accepted by this server")
+	}
+	err := verifyJWT(token, p.secret, time.Now())
+	if err != nil {
+		return publicPermission, errors.Wrap(err, "invalid bearer token")
+	}
+	return adminPermission, nil
+}
+
+// verifyJWT checks that the token is a HS256 json web token signed using the secret,
+// and that it's valid at the time now
+func verifyJWT(token string, secret []byte, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed jwt")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return err
+	}
+	if header.Alg != "HS256" {
+		return fmt.Errorf("unsupported jwt algorithm %q, only HS256 is supported", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.Wrap(err, "malformed jwt signature")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("jwt signature doesn't match")
+	}
+	var claims struct {
+		Exp *float64 `json:"exp"`
+		Nbf *float64 `json:"nbf"`
+	}
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return err
+	}
+	if claims.Exp != nil && float64(now.Unix()) >= *claims.Exp {
+		return errors.New("jwt has expired")
+	}
+	if claims.Nbf != nil && float64(now.Unix()) < *claims.Nbf {
+		return errors.New("jwt isn't valid yet")
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.Wrap(err, "malformed jwt")
+	}
+	return errors.Wrap(json.Unmarshal(raw, v), "malformed jwt")
+}
+
+// roleKey is the context key of the caller's role
+type roleKey struct{}
+
+// roleOf returns the role added to the request's context by authorize
+func roleOf(r *http.Request) permission {
+	role, _ := r.Context().Value(roleKey{}).(permission)
+	return role
+}
+
+// authorize rejects requests from origins that aren't allowed and requests with
+// invalid bearer tokens, and answers cors preflight requests. The caller's role is
+// added to the request's context.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if !s.policy.allowOrigin(origin) {
+			writeHTTPError(w, http.StatusForbidden, fmt.Sprintf("origin %s is not allowed", origin))
+			return
+		}
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		role, err := s.policy.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeHTTPError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), roleKey{}, role)))
+	})
+}
+
+// require only lets callers permitted to use the namespace through to next
+func (s *Server) require(namespace string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.policy.allowedNamespace(namespace, roleOf(r)) {
+			writeHTTPError(w, http.StatusUnauthorized, fmt.Sprintf("%s requires authorization", namespace))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized wraps call, so that it only calls the methods the role can use
+func (s *Server) authorized(role permission, call caller) caller {
+	return func(req *rpcMessage) *rpcMessage {
+		if !s.policy.allowed(req.Method, role) {
+			return errorMessage(req.ID, unauthorizedCode, fmt.Sprintf("the method %s requires authorization", req.Method))
+		}
+		return call(req)
+	}
+}
+
+// writeHTTPError responds with a json rpc error and an http status code, for requests
+// that are rejected before they're handled
+func writeHTTPError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(rpcError(nil, unauthorizedCode, msg))
+}
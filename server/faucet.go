@@ -0,0 +1,328 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/evan-forbes/ethlab/thereum"
+	"github.com/pkg/errors"
+)
+
+////////////////////////////////
+//	Faucet Policy
+//////////////////////////////
+
+// faucetError is a refused faucet request, along with the http status to respond with
+type faucetError struct {
+	status     int
+	reason     string        // reason is a short name for why the request was refused
+	retryAfter time.Duration // retryAfter is how long to wait before the request can succeed
+	txHash     *common.Hash  // txHash is the transaction that sent the eth, if it was sent
+	pending    bool          // pending is true if the transaction might still be mined
+	err        error
+}
+
+func (e *faucetError) Error() string { return e.err.Error() }
+
+func refuse(status int, reason string, format string, args ...interface{}) *faucetError {
+	return &faucetError{status: status, reason: reason, err: fmt.Errorf(format, args...)}
+}
+
+// faucetResp is the json response of the faucet. Successful requests include the
+// transaction that sent the eth, the block it was mined in, and the recipient's
+// balance after that block. Refused requests include the reason they were refused,
+// and how many seconds to wait before trying again if waiting helps.
+type faucetResp struct {
+	Message     string       `json:"message"`
+	Reason      string       `json:"reason,omitempty"`
+	RetryAfter  int64        `json:"retry_after,omitempty"`
+	TxHash      *common.Hash `json:"tx_hash,omitempty"`
+	BlockNumber uint64       `json:"block_number,omitempty"`
+	Balance     *big.Int     `json:"balance,omitempty"`
+}
+
+// writeFaucetError responds to a refused faucet request
+func writeFaucetError(w http.ResponseWriter, err error) {
+	refused, ok := err.(*faucetError)
+	if !ok {
+		refused = &faucetError{status: http.StatusInternalServerError, reason: "internal", err: err}
+	}
+	resp := faucetResp{Message: refused.Error(), Reason: refused.reason, TxHash: refused.txHash}
+	if refused.retryAfter > 0 {
+		resp.RetryAfter = int64(math.Ceil(refused.retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.FormatInt(resp.RetryAfter, 10))
+	}
+	out, _ := json.Marshal(resp)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(refused.status)
+	w.Write(out)
+}
+
+// defaultReceiptTimeout is how long the faucet waits for the eth it sends to be
+// mined, unless configured otherwise. It's below the server's write timeout, so the
+// response is written before the connection's deadline passes.
+const defaultReceiptTimeout = 15 * time.Second
+
+// faucetPolicy limits who the faucet sends eth to, and how much. Limits that aren't
+// configured don't limit anything.
+type faucetPolicy struct {
+	maxDrip *big.Int
+	budget  *big.Int
+	spent   *big.Int
+	allow   map[common.Address]bool // only these addresses are funded, unless empty
+	deny    map[common.Address]bool
+	address *quota
+	ip      *quota
+	timeout time.Duration // timeout is how long to wait for the eth sent to be mined
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// newFaucetPolicy parses the faucet config
+func newFaucetPolicy(cfg thereum.FaucetConfig) (*faucetPolicy, error) {
+	p := &faucetPolicy{
+		spent:   new(big.Int),
+		allow:   make(map[common.Address]bool),
+		deny:    make(map[common.Address]bool),
+		timeout: defaultReceiptTimeout,
+		now:     time.Now,
+	}
+	if cfg.ReceiptTimeout > 0 {
+		p.timeout = time.Duration(cfg.ReceiptTimeout) * time.Second
+	}
+	var err error
+	p.maxDrip, err = parseWei("max_drip", cfg.MaxDrip)
+	if err != nil {
+		return nil, err
+	}
+	p.budget, err = parseWei("budget", cfg.Budget)
+	if err != nil {
+		return nil, err
+	}
+	addressCap, err := parseWei("address_daily_cap", cfg.AddressDailyCap)
+	if err != nil {
+		return nil, err
+	}
+	ipCap, err := parseWei("ip_daily_cap", cfg.IPDailyCap)
+	if err != nil {
+		return nil, err
+	}
+	p.address = newQuota(time.Duration(cfg.AddressCooldown)*time.Second, addressCap)
+	p.ip = newQuota(time.Duration(cfg.IPCooldown)*time.Second, ipCap)
+	for _, list := range []struct {
+		addrs []string
+		set   map[common.Address]bool
+	}{{cfg.Allow, p.allow}, {cfg.Deny, p.deny}} {
+		for _, addr := range list.addrs {
+			if !common.IsHexAddress(addr) {
+				return nil, fmt.Errorf("invalid faucet address %q", addr)
+			}
+			list.set[common.HexToAddress(addr)] = true
+		}
+	}
+	return p, nil
+}
+
+// SetFaucet limits the eth sent by the faucet using the faucet config. It must be
+// called before the server starts serving, and after changing its WriteTimeout.
+func (s *Server) SetFaucet(cfg thereum.FaucetConfig) error {
+	p, err := newFaucetPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	if s.WriteTimeout > 0 && p.timeout >= s.WriteTimeout {
+		return fmt.Errorf("receipt_timeout must be less than the server's write timeout of %s", s.WriteTimeout)
+	}
+	s.faucet = p
+	return nil
+}
+
+// parseWei parses a decimal amount of wei, returning nil if it's empty
+func parseWei(name, s string) (*big.Int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	out, ok := new(big.Int).SetString(s, 10)
+	if !ok || out.Sign() < 0 {
+		return nil, errors.Errorf("invalid faucet %s %q, it must be a decimal amount of wei", name, s)
+	}
+	return out, nil
+}
+
+// reserve checks if the request qualifies for free eth, and if it does, counts the
+// amount against each limit. The returned undo func releases the amount if it
+// couldn't be sent.
+func (p *faucetPolicy) reserve(addr common.Address, ip string, amount *big.Int) (undo func(), err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	switch {
+	case p.deny[addr]:
+		return nil, refuse(http.StatusForbidden, "denied", "%s is not allowed to use the faucet", addr.Hex())
+	case len(p.allow) > 0 && !p.allow[addr]:
+		return nil, refuse(http.StatusForbidden, "not_allowed", "%s is not on the faucet's allowlist", addr.Hex())
+	case p.maxDrip != nil && amount.Cmp(p.maxDrip) > 0:
+		return nil, refuse(http.StatusBadRequest, "max_drip", "the faucet sends at most %s wei per request", p.maxDrip)
+	case p.budget != nil && new(big.Int).Add(p.spent, amount).Cmp(p.budget) > 0:
+		left := new(big.Int).Sub(p.budget, p.spent)
+		return nil, refuse(http.StatusServiceUnavailable, "budget", "the faucet only has %s wei left to send", left)
+	}
+	err = p.address.check("address", addr.Hex(), amount, now)
+	if err != nil {
+		return nil, err
+	}
+	err = p.ip.check("ip", ip, amount, now)
+	if err != nil {
+		return nil, err
+	}
+	undoAddress := p.address.take(addr.Hex(), amount, now)
+	undoIP := p.ip.take(ip, amount, now)
+	p.spent.Add(p.spent, amount)
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		undoAddress()
+		undoIP()
+		p.spent.Sub(p.spent, amount)
+	}, nil
+}
+
+// quota limits how often a single address or ip can use the faucet, and how much it
+// can receive each day
+type quota struct {
+	cooldown time.Duration
+	dailyCap *big.Int
+	last     map[string]time.Time
+	days     map[string]*dailyTotal
+}
+
+// dailyTotal is the amount received during a single day, counted in UTC
+type dailyTotal struct {
+	day    int64
+	amount *big.Int
+}
+
+func newQuota(cooldown time.Duration, dailyCap *big.Int) *quota {
+	return &quota{
+		cooldown: cooldown,
+		dailyCap: dailyCap,
+		last:     make(map[string]time.Time),
+		days:     make(map[string]*dailyTotal),
+	}
+}
+
+func dayOf(t time.Time) int64 {
+	return t.Unix() / int64(24*time.Hour/time.Second)
+}
+
+// check reports if key can receive the amount. Callers must hold the policy's lock.
+func (q *quota) check(kind, key string, amount *big.Int, now time.Time) error {
+	if last, has := q.last[key]; has && q.cooldown > 0 && now.Sub(last) < q.cooldown {
+		wait := q.cooldown - now.Sub(last)
+		err := refuse(http.StatusTooManyRequests, kind+"_cooldown", "%s %s has to wait %s before using the faucet again", kind, key, wait.Round(time.Second))
+		err.retryAfter = wait
+		return err
+	}
+	if q.dailyCap == nil {
+		return nil
+	}
+	received := new(big.Int)
+	if total, has := q.days[key]; has && total.day == dayOf(now) {
+		received.Set(total.amount)
+	}
+	if received.Add(received, amount).Cmp(q.dailyCap) > 0 {
+		tomorrow := time.Unix((dayOf(now)+1)*int64(24*time.Hour/time.Second), 0)
+		err := refuse(http.StatusTooManyRequests, kind+"_daily_cap", "%s %s can receive at most %s wei per day", kind, key, q.dailyCap)
+		err.retryAfter = tomorrow.Sub(now)
+		return err
+	}
+	return nil
+}
+
+// take counts the amount against key's quota, returning a func to undo it. Callers
+// must hold the policy's lock.
+func (q *quota) take(key string, amount *big.Int, now time.Time) (undo func()) {
+	last, hadLast := q.last[key]
+	q.last[key] = now
+	total, has := q.days[key]
+	if !has || total.day != dayOf(now) {
+		total = &dailyTotal{day: dayOf(now), amount: new(big.Int)}
+		q.days[key] = total
+	}
+	total.amount.Add(total.amount, amount)
+	return func() {
+		if hadLast {
+			q.last[key] = last
+		} else {
+			delete(q.last, key)
+		}
+		total.amount.Sub(total.amount, amount)
+	}
+}
+
+// drip sends amount from the root account to addr, and waits until the transaction
+// is mined. The recipient's balance after the block it was mined in is returned with
+// the receipt. Sends from the root account share its nonces with every other request
+// the server signs, so concurrent requests never reuse one.
+func (s *Server) drip(ctx context.Context, addr common.Address, amount *big.Int) (*types.Receipt, *big.Int, error) {
+	root, has := s.back.Accounts["root"]
+	if !has {
+		return nil, nil, refuse(http.StatusServiceUnavailable, "no_root", "the faucet has no root account to send eth from")
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.faucet.timeout)
+	defer cancel()
+
+	// subscribe before sending, so the block the transaction is mined in isn't missed
+	heads := make(chan *types.Header)
+	sub := s.back.Events.SubscribeNewHeads(heads)
+	defer sub.Unsubscribe()
+
+	tx, err := s.sendFaucetTx(thereum.TxArgs{
+		From:  root.Address,
+		To:    &addr,
+		Value: (*hexutil.Big)(amount),
+	})
+	if err != nil {
+		return nil, nil, refuse(http.StatusInternalServerError, "send_failed", "could not send eth: %s", err)
+	}
+	hash := tx.Hash()
+	for {
+		rcpt, err := s.back.TxReceipt(hash)
+		if err != nil {
+			return nil, nil, refuse(http.StatusInternalServerError, "internal", "could not get receipt: %s", err)
+		}
+		if rcpt != nil {
+			if rcpt.Status != types.ReceiptStatusSuccessful {
+				refused := refuse(http.StatusInternalServerError, "tx_failed", "transaction %s failed in block %d", hash.Hex(), rcpt.BlockNumber)
+				refused.txHash = &hash
+				return nil, nil, refused
+			}
+			balance, err := s.back.BalanceAt(ctx, addr, rcpt.BlockNumber)
+			if err != nil {
+				return nil, nil, refuse(http.StatusInternalServerError, "internal", "could not get balance: %s", err)
+			}
+			return rcpt, balance, nil
+		}
+		select {
+		case <-heads:
+		case err := <-sub.Err():
+			refused := refuse(http.StatusInternalServerError, "internal", "stopped waiting for transaction %s: %v", hash.Hex(), err)
+			refused.txHash, refused.pending = &hash, true
+			return nil, nil, refused
+		case <-ctx.Done():
+			refused := refuse(http.StatusGatewayTimeout, "receipt_timeout", "transaction %s wasn't mined in time", hash.Hex())
+			refused.txHash, refused.pending = &hash, true
+			return nil, nil, refused
+		}
+	}
+}
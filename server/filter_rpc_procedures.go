@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/eth/filters"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/evan-forbes/ethlab/thereum"
+)
+
+////////////////////////////////
+//	Polling Filters
+//////////////////////////////
+
+// newFilter installs a log filter that can be polled using eth_getFilterChanges
+func newFilter(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":[{"fromBlock":"0x1","address":["0x8888f1f195afa192cfee860698584c030f4c9db1"],"topics":[null,["0x000000000000000000000000a94f5374fce5edbc8e2a8697c15331677e6ebf0b"]]}]
+	var crit filters.FilterCriteria
+	err := unmarshalParams(msg, 1, &crit)
+	if err != nil {
+		return nil, err
+	}
+	id, err := eth.NewLogFilter(ethereum.FilterQuery(crit))
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  id,
+	}
+	return out, nil
+}
+
+// newBlockFilter installs a filter collecting the hashes of new blocks
+func newBlockFilter(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  eth.NewBlockFilter(),
+	}
+	return out, nil
+}
+
+// newPendingTxFilter installs a filter collecting the hashes of transactions as
+// they're added to the txpool
+func newPendingTxFilter(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  eth.NewPendingTxFilter(),
+	}
+	return out, nil
+}
+
+// getFilterChanges returns the logs or hashes collected by a filter since it was
+// last polled
+func getFilterChanges(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0x16"]
+	var id rpc.ID
+	err := unmarshalParams(msg, 1, &id)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := eth.FilterChanges(id)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  changes,
+	}
+	return out, nil
+}
+
+// getFilterLogs returns every log matching a log filter
+func getFilterLogs(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0x16"]
+	var id rpc.ID
+	err := unmarshalParams(msg, 1, &id)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := eth.FilterLogs(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  logs,
+	}
+	return out, nil
+}
+
+// uninstallFilter removes a filter, returning false if it wasn't installed
+func uninstallFilter(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0xb"]
+	var id rpc.ID
+	err := unmarshalParams(msg, 1, &id)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  eth.UninstallFilter(id),
+	}
+	return out, nil
+}
+
+// getLogs searches the chain for logs matching a filter object
+func getLogs(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":[{"blockHash":"0x7c5a35e9cb3e8ae0e221ab470abae9d446c3a5626ce6689fc777dcffcab52c70","topics":["0x241ea03ca20251805084d27d4440371c34a0b85ff108f6bb5611248f73818b80"]}]
+	var crit filters.FilterCriteria
+	err := unmarshalParams(msg, 1, &crit)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := eth.Logs(context.Background(), ethereum.FilterQuery(crit))
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  logs,
+	}
+	return out, nil
+}
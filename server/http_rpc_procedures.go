@@ -9,9 +9,12 @@ import (
 	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/evan-forbes/ethlab/thereum"
+	"github.com/evan-forbes/ethlab/txpool"
 	"github.com/pkg/errors"
 )
 
@@ -23,25 +26,13 @@ import (
 func faucet(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
 	return nil, nil
 }
+
+// nullProcedure is used for methods that are recognized, but not supported yet
 func nullProcedure(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
-	nullMessage := rpcMessage{
-		Version: "2.0",
-		ID:      1,
-		Error: &jsonError{
-			Code:    999,
-			Message: "specified method is not registered or supported",
-		},
+	return nil, &codedError{
+		code: methodNotFoundCode,
+		err:  fmt.Errorf("the method %s is not supported", msg.Method),
 	}
-	return &nullMessage, nil
-}
-
-type sendTxParams struct {
-	From     common.Address `json:"from"`
-	To       common.Address `json:"to"`
-	Gas      uint64         `json:"gas"`
-	GasPrice *big.Int       `json:"gasPrice"`
-	Value    *big.Int       `json:"value"`
-	Data     string         `json:"data"`
 }
 
 // sendRawTx handles a singed raw transaction provided in an rpc message
@@ -50,15 +41,35 @@ func sendRawTx(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
 	var hexTx []string
 	err := json.Unmarshal(msg.Params, &hexTx)
 	if err != nil {
-		return nil, err
+		return nil, invalidParams(err)
 	}
 	// ensure that some data was passed throught the rpc msg
 	if len(hexTx) == 0 {
-		return nil, errors.New("no parameters provided for raw transaction")
+		return nil, invalidParams(errors.New("no parameters provided for raw transaction"))
 	}
 	// unmarshal the hex bytes into a transaction
+	tx, err := decodeRawTx(hexTx[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// add the transaction to thereum
+	err = eth.AddTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  tx.Hash().Hex(),
+	}
+
+	return out, nil
+}
+
+// decodeRawTx unmarshals a hex encoded, rlp encoded, signed transaction
+func decodeRawTx(hexTx string) (*types.Transaction, error) {
 	var tx types.Transaction
-	txBytes, err := hex.DecodeString(strings.Replace(hexTx[0], "0x", "", 1))
+	txBytes, err := hex.DecodeString(strings.Replace(hexTx, "0x", "", 1))
 	if err != nil {
 		return nil, err
 	}
@@ -66,21 +77,436 @@ func sendRawTx(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
 	if err != nil {
 		return nil, err
 	}
+	return &tx, nil
+}
+
+// bundleParams is the flashbots style object used to send a bundle of raw transactions
+type bundleParams struct {
+	Txs          []string     `json:"txs"`
+	BlockNumber  *hexutil.Big `json:"blockNumber"`
+	RevertOnFail bool         `json:"revertOnFail"`
+}
+
+type bundleResult struct {
+	BundleHash common.Hash `json:"bundleHash"`
+}
+
+// sendBundle queues a bundle of signed raw transactions that are included in the
+// same block, one after another, or not at all
+func sendBundle(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":[{"txs": ["0x..", "0x.."], "blockNumber": "0x10", "revertOnFail": true}]
+	var params []bundleParams
+	err := json.Unmarshal(msg.Params, &params)
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+	if len(params) == 0 {
+		return nil, invalidParams(errors.New("no parameters provided for bundle"))
+	}
+	bundle := &txpool.Bundle{RevertOnFail: params[0].RevertOnFail}
+	if params[0].BlockNumber != nil {
+		bundle.BlockNumber = params[0].BlockNumber.ToInt()
+	}
+	for _, hexTx := range params[0].Txs {
+		tx, err := decodeRawTx(hexTx)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Transactions = append(bundle.Transactions, tx)
+	}
+	hash, err := eth.AddBundle(bundle)
 	if err != nil {
 		return nil, err
 	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  bundleResult{BundleHash: hash},
+	}
+	return out, nil
+}
 
-	// add the transaction to thereum
-	err = eth.AddTx(&tx)
+// scheduleParams describes when a scheduled transaction should be released
+type scheduleParams struct {
+	BlockNumber *hexutil.Big    `json:"blockNumber"`
+	Timestamp   *hexutil.Uint64 `json:"timestamp"` // Timestamp is in chain time, as used by block timestamps
+}
+
+// scheduledTx is the json representation of a scheduled transaction
+type scheduledTx struct {
+	Hash        common.Hash     `json:"hash"`
+	Raw         hexutil.Bytes   `json:"raw"`
+	BlockNumber *hexutil.Big    `json:"blockNumber,omitempty"`
+	Timestamp   *hexutil.Uint64 `json:"timestamp,omitempty"`
+}
+
+// scheduleTx holds a signed raw transaction until the provided block number or
+// timestamp is reached
+func scheduleTx(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0xf86d...", {"blockNumber": "0x78", "timestamp": "0x4b0"}]
+	var params []json.RawMessage
+	err := json.Unmarshal(msg.Params, &params)
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+	if len(params) != 2 {
+		return nil, invalidParams(errors.New("2 arguments needed in parameters"))
+	}
+	var hexTx string
+	err = json.Unmarshal(params[0], &hexTx)
+	if err != nil {
+		return nil, invalidParams(errors.Wrap(err, "first arg in params must be a raw transaction"))
+	}
+	tx, err := decodeRawTx(hexTx)
+	if err != nil {
+		return nil, err
+	}
+	var when scheduleParams
+	err = json.Unmarshal(params[1], &when)
+	if err != nil {
+		return nil, invalidParams(errors.Wrap(err, "second arg in params must contain a blockNumber or timestamp"))
+	}
+	var number *big.Int
+	if when.BlockNumber != nil {
+		number = when.BlockNumber.ToInt()
+	}
+	var timestamp uint64
+	if when.Timestamp != nil {
+		timestamp = uint64(*when.Timestamp)
+	}
+	err = eth.Schedule(tx, number, timestamp)
 	if err != nil {
 		return nil, err
 	}
 	out := &rpcMessage{
 		Version: "2.0",
-		ID:      1,
 		Result:  tx.Hash().Hex(),
 	}
+	return out, nil
+}
 
+// cancelScheduledTx stops a scheduled transaction from being released
+func cancelScheduledTx(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	var hashes []common.Hash
+	err := json.Unmarshal(msg.Params, &hashes)
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+	if len(hashes) == 0 {
+		return nil, invalidParams(errors.New("no parameters provided for transaction hash"))
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  eth.CancelScheduled(hashes[0]),
+	}
+	return out, nil
+}
+
+// listScheduledTxs returns every transaction waiting to be released
+func listScheduledTxs(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	scheduled := eth.Scheduled()
+	result := make([]scheduledTx, 0, len(scheduled))
+	for _, sch := range scheduled {
+		raw, err := rlp.EncodeToBytes(sch.Tx)
+		if err != nil {
+			return nil, err
+		}
+		stx := scheduledTx{Hash: sch.Tx.Hash(), Raw: raw}
+		if sch.BlockNumber != nil {
+			stx.BlockNumber = (*hexutil.Big)(sch.BlockNumber)
+		}
+		if sch.Timestamp != 0 {
+			stx.Timestamp = (*hexutil.Uint64)(&sch.Timestamp)
+		}
+		result = append(result, stx)
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  result,
+	}
+	return out, nil
+}
+
+// rpcTransaction is the geth compatible json representation of a transaction
+type rpcTransaction struct {
+	BlockHash        *common.Hash    `json:"blockHash"`
+	BlockNumber      *hexutil.Big    `json:"blockNumber"`
+	From             common.Address  `json:"from"`
+	Gas              hexutil.Uint64  `json:"gas"`
+	GasPrice         *hexutil.Big    `json:"gasPrice"`
+	Hash             common.Hash     `json:"hash"`
+	Input            hexutil.Bytes   `json:"input"`
+	Nonce            hexutil.Uint64  `json:"nonce"`
+	To               *common.Address `json:"to"`
+	TransactionIndex *hexutil.Uint64 `json:"transactionIndex"`
+	Value            *hexutil.Big    `json:"value"`
+	V                *hexutil.Big    `json:"v"`
+	R                *hexutil.Big    `json:"r"`
+	S                *hexutil.Big    `json:"s"`
+	LinkedSet        *common.Hash    `json:"linkedSet,omitempty"` // LinkedSet is only set for pooled linked transactions
+}
+
+// newRPCTransaction formats a transaction the same way geth does. An empty block hash
+// is used for transactions that have not been included in a block.
+func newRPCTransaction(tx *types.Transaction, blockHash common.Hash, blockNumber uint64, index uint64) *rpcTransaction {
+	var signer types.Signer = types.FrontierSigner{}
+	if tx.Protected() {
+		signer = types.NewEIP155Signer(tx.ChainId())
+	}
+	from, _ := types.Sender(signer, tx)
+	v, r, s := tx.RawSignatureValues()
+
+	result := &rpcTransaction{
+		From:     from,
+		Gas:      hexutil.Uint64(tx.Gas()),
+		GasPrice: (*hexutil.Big)(tx.GasPrice()),
+		Hash:     tx.Hash(),
+		Input:    hexutil.Bytes(tx.Data()),
+		Nonce:    hexutil.Uint64(tx.Nonce()),
+		To:       tx.To(),
+		Value:    (*hexutil.Big)(tx.Value()),
+		V:        (*hexutil.Big)(v),
+		R:        (*hexutil.Big)(r),
+		S:        (*hexutil.Big)(s),
+	}
+	if blockHash != (common.Hash{}) {
+		result.BlockHash = &blockHash
+		result.BlockNumber = (*hexutil.Big)(new(big.Int).SetUint64(blockNumber))
+		result.TransactionIndex = (*hexutil.Uint64)(&index)
+	}
+	return result
+}
+
+// txPoolContent returns every pooled transaction, grouped by sender and nonce
+func txPoolContent(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	snap := eth.TxPoolContent()
+	format := func(ptx *txpool.PooledTx) interface{} {
+		rtx := newRPCTransaction(ptx.Tx, common.Hash{}, 0, 0)
+		if ptx.Linked() {
+			set := ptx.Set
+			rtx.LinkedSet = &set
+		}
+		return rtx
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result: map[string]map[string]map[string]interface{}{
+			"pending": flattenPool(snap.Pending, format),
+			"queued":  flattenPool(snap.Queued, format),
+		},
+	}
+	return out, nil
+}
+
+// txPoolStatus returns the number of pending and queued transactions
+func txPoolStatus(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	pending, queued := eth.TxPoolContent().Len()
+	out := &rpcMessage{
+		Version: "2.0",
+		Result: map[string]hexutil.Uint{
+			"pending": hexutil.Uint(pending),
+			"queued":  hexutil.Uint(queued),
+		},
+	}
+	return out, nil
+}
+
+// txPoolInspect returns a short text summary of every pooled transaction, grouped by
+// sender and nonce
+func txPoolInspect(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	snap := eth.TxPoolContent()
+	format := func(ptx *txpool.PooledTx) interface{} {
+		tx := ptx.Tx
+		if to := tx.To(); to != nil {
+			return fmt.Sprintf("%s: %v wei + %v gas × %v wei", to.Hex(), tx.Value(), tx.Gas(), tx.GasPrice())
+		}
+		return fmt.Sprintf("contract creation: %v wei + %v gas × %v wei", tx.Value(), tx.Gas(), tx.GasPrice())
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+		Result: map[string]map[string]map[string]interface{}{
+			"pending": flattenPool(snap.Pending, format),
+			"queued":  flattenPool(snap.Queued, format),
+		},
+	}
+	return out, nil
+}
+
+// flattenPool formats pooled transactions keyed by checksummed sender and decimal
+// nonce, which is the layout used by geth's txpool namespace
+func flattenPool(group map[common.Address]map[uint64]*txpool.PooledTx, format func(*txpool.PooledTx) interface{}) map[string]map[string]interface{} {
+	out := make(map[string]map[string]interface{}, len(group))
+	for author, txs := range group {
+		dump := make(map[string]interface{}, len(txs))
+		for nonce, ptx := range txs {
+			dump[fmt.Sprintf("%d", nonce)] = format(ptx)
+		}
+		out[author.Hex()] = dump
+	}
+	return out
+}
+
+// unmarshalParams decodes positional parameters into args, in order. Only the first
+// required parameters have to be provided, missing optional args are left untouched.
+func unmarshalParams(msg *rpcMessage, required int, args ...interface{}) error {
+	var params []json.RawMessage
+	if len(msg.Params) > 0 {
+		err := json.Unmarshal(msg.Params, &params)
+		if err != nil {
+			return invalidParams(err)
+		}
+	}
+	if len(params) < required {
+		return invalidParams(fmt.Errorf("%d arguments needed in parameters", required))
+	}
+	for i, param := range params {
+		if i >= len(args) {
+			break
+		}
+		err := json.Unmarshal(param, args[i])
+		if err != nil {
+			return invalidParams(errors.Wrapf(err, "could not parse argument %d in params", i+1))
+		}
+	}
+	return nil
+}
+
+// rpcMarshalBlock formats a block the same way geth does, including either the full
+// transactions or only their hashes
+func rpcMarshalBlock(eth *thereum.Thereum, block *types.Block, fullTx bool) map[string]interface{} {
+	head := block.Header()
+	fields := map[string]interface{}{
+		"number":           (*hexutil.Big)(head.Number),
+		"hash":             block.Hash(),
+		"parentHash":       head.ParentHash,
+		"nonce":            head.Nonce,
+		"mixHash":          head.MixDigest,
+		"sha3Uncles":       head.UncleHash,
+		"logsBloom":        head.Bloom,
+		"stateRoot":        head.Root,
+		"miner":            head.Coinbase,
+		"difficulty":       (*hexutil.Big)(head.Difficulty),
+		"totalDifficulty":  (*hexutil.Big)(eth.TotalDifficulty(block.Hash())),
+		"extraData":        hexutil.Bytes(head.Extra),
+		"size":             hexutil.Uint64(block.Size()),
+		"gasLimit":         hexutil.Uint64(head.GasLimit),
+		"gasUsed":          hexutil.Uint64(head.GasUsed),
+		"timestamp":        hexutil.Uint64(head.Time),
+		"transactionsRoot": head.TxHash,
+		"receiptsRoot":     head.ReceiptHash,
+	}
+	txs := block.Transactions()
+	transactions := make([]interface{}, len(txs))
+	for i, tx := range txs {
+		if fullTx {
+			transactions[i] = newRPCTransaction(tx, block.Hash(), block.NumberU64(), uint64(i))
+			continue
+		}
+		transactions[i] = tx.Hash()
+	}
+	fields["transactions"] = transactions
+	uncles := block.Uncles()
+	uncleHashes := make([]common.Hash, len(uncles))
+	for i, uncle := range uncles {
+		uncleHashes[i] = uncle.Hash()
+	}
+	fields["uncles"] = uncleHashes
+	return fields
+}
+
+// getBlockByNumber returns the block with the provided number or tag, or null if it
+// doesn't exist
+func getBlockByNumber(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0x1b4", true]
+	var number rpc.BlockNumber
+	var fullTx bool
+	err := unmarshalParams(msg, 1, &number, &fullTx)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+	}
+	block, err := eth.BlockByTag(context.Background(), number)
+	if err == nil {
+		out.Result = rpcMarshalBlock(eth, block, fullTx)
+	}
+	return out, nil
+}
+
+// getBlockByHash returns the block with the provided hash, or null if it doesn't exist
+func getBlockByHash(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0xdc0818cf78f21a8e70579cb46a43643f78291264dda342ae31049421c82d21ae", false]
+	var hash common.Hash
+	var fullTx bool
+	err := unmarshalParams(msg, 1, &hash, &fullTx)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+	}
+	block, err := eth.BlockByHash(context.Background(), hash)
+	if err == nil {
+		out.Result = rpcMarshalBlock(eth, block, fullTx)
+	}
+	return out, nil
+}
+
+// getTxByHash returns a mined or pooled transaction, or null if it can't be found
+func getTxByHash(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b"]
+	var hash common.Hash
+	err := unmarshalParams(msg, 1, &hash)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+	}
+	tx, blockHash, number, index, err := eth.TransactionByHash(hash)
+	if err == nil {
+		out.Result = newRPCTransaction(tx, blockHash, number, index)
+	}
+	return out, nil
+}
+
+// getTxByBlockNumberAndIndex returns the transaction at an index of a block, or null
+// if it doesn't exist
+func getTxByBlockNumberAndIndex(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0x29c", "0x0"]
+	var number rpc.BlockNumber
+	var index hexutil.Uint
+	err := unmarshalParams(msg, 2, &number, &index)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+	}
+	block, err := eth.BlockByTag(context.Background(), number)
+	if err == nil && uint64(index) < uint64(block.Transactions().Len()) {
+		tx := block.Transactions()[index]
+		out.Result = newRPCTransaction(tx, block.Hash(), block.NumberU64(), uint64(index))
+	}
+	return out, nil
+}
+
+// getBlockTxCountByHash returns the number of transactions in a block, or null if
+// the block doesn't exist
+func getBlockTxCountByHash(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0xb903239f8543d04b5dc1ba6579132b143087c68db1b2168786408fcbce568238"]
+	var hash common.Hash
+	err := unmarshalParams(msg, 1, &hash)
+	if err != nil {
+		return nil, err
+	}
+	out := &rpcMessage{
+		Version: "2.0",
+	}
+	block, err := eth.BlockByHash(context.Background(), hash)
+	if err == nil {
+		out.Result = hexutil.Uint(block.Transactions().Len())
+	}
 	return out, nil
 }
 
@@ -91,15 +517,14 @@ func getTxReceipt(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
 	var hexTx []string
 	err := json.Unmarshal(msg.Params, &hexTx)
 	if err != nil {
-		return nil, err
+		return nil, invalidParams(err)
 	}
 	// ensure that some data was passed throught the rpc msg
 	if len(hexTx) == 0 {
-		return nil, errors.New("no parameters provided for raw transaction")
+		return nil, invalidParams(errors.New("no parameters provided for raw transaction"))
 	}
 	// unmarshal the hex bytes into a transaction
 	hash := common.HexToHash(hexTx[0])
-	fmt.Println("hash", hash)
 	// fetch the receipt
 	receipt, err := eth.TxReceipt(hash)
 	if err != nil {
@@ -112,114 +537,140 @@ func getTxReceipt(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
 	}
 	out := &rpcMessage{
 		Version: "2.0",
-		ID:      1,
 		Result:  receipt,
 	}
 	return out, nil
 }
 
+// latestBlock is the default block parameter used by state reading procedures
+func latestBlock() rpc.BlockNumberOrHash {
+	return rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+}
+
 // getTxCount returns the number of transaction sent from an address at a given
-// block
+// block. The pending count includes transactions waiting in the txpool.
 func getTxCount(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
 	// "params":["0x407d73d8a49eeb85d32cf465507dd71d507100c1","latest"]
-	var params []interface{}
-	err := json.Unmarshal(msg.Params, &params)
+	var addr common.Address
+	block := latestBlock()
+	err := unmarshalParams(msg, 1, &addr, &block)
 	if err != nil {
 		return nil, err
 	}
-	if len(params) != 2 {
-		return nil, errors.New("2 arguments needed in parameters")
+	var count uint64
+	if number, ok := block.Number(); ok && number == rpc.PendingBlockNumber {
+		count, err = eth.PendingNonce(addr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		state, err := eth.StateAtBlock(context.Background(), block)
+		if err != nil {
+			return nil, err
+		}
+		count = state.GetNonce(addr)
 	}
-	hexAddr, ok := params[0].(string)
-	if !ok {
-		return nil, errors.New("first arg in params must be a string representing an address")
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  hexutil.Uint64(count),
 	}
-	addr := common.HexToAddress(hexAddr)
+	return out, nil
+}
 
-	// hexHash, ok := params[1].(string)
-	// if !ok {
-	// 	return nil, errors.New("block numbers not yet supported, try a block hash")
-	// }
-	// type switch, to add numbers later?
-	var hsh common.Hash
-	hsh = eth.LatestBlock().Hash()
-	count, err := eth.TransactionCountByAddress(context.Background(), addr, hsh)
+// getBalanceAt returns the balance of an address at a given block
+func getBalanceAt(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0x407d73d8a49eeb85d32cf465507dd71d507100c1", "latest"]
+	var addr common.Address
+	block := latestBlock()
+	err := unmarshalParams(msg, 1, &addr, &block)
+	if err != nil {
+		return nil, err
+	}
+	state, err := eth.StateAtBlock(context.Background(), block)
 	if err != nil {
 		return nil, err
 	}
 	out := &rpcMessage{
 		Version: "2.0",
-		ID:      1,
-		Result:  count,
+		Result:  (*hexutil.Big)(state.GetBalance(addr)),
 	}
 	return out, nil
 }
 
-func getBalanceAt(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
-	// "params":["0x407d73d8a49eeb85d32cf465507dd71d507100c1", "latest"]
-	var params []interface{}
-	err := json.Unmarshal(msg.Params, &params)
+// getCode returns the code deployed at an address at a given block
+func getCode(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b", "latest"]
+	var addr common.Address
+	block := latestBlock()
+	err := unmarshalParams(msg, 1, &addr, &block)
 	if err != nil {
 		return nil, err
 	}
-	if len(params) != 2 {
-		return nil, errors.New("2 arguments needed in parameters")
+	state, err := eth.StateAtBlock(context.Background(), block)
+	if err != nil {
+		return nil, err
 	}
-	hexAddr, ok := params[0].(string)
-	if !ok {
-		return nil, errors.New("first arg in params must be a string representing an address")
+	out := &rpcMessage{
+		Version: "2.0",
+		Result:  hexutil.Bytes(state.GetCode(addr)),
 	}
-	addr := common.HexToAddress(hexAddr)
+	return out, nil
+}
 
-	// hexHash, ok := params[1].(string)
-	// if !ok {
-	// 	return nil, errors.New("block numbers not yet supported, try a block hash")
-	// }
-	bal, err := eth.BalanceAt(context.Background(), addr, nil)
+// getStorageAt returns the value stored at a position in an address's storage at a
+// given block
+func getStorageAt(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
+	// "params":["0x295a70b2de5e3953354a6a8344e616ed314d7251", "0x0", {"blockHash": "0x..."}]
+	var addr common.Address
+	var key string
+	block := latestBlock()
+	err := unmarshalParams(msg, 2, &addr, &key, &block)
 	if err != nil {
 		return nil, err
 	}
+	slot, err := storageKey(key)
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+	state, err := eth.StateAtBlock(context.Background(), block)
+	if err != nil {
+		return nil, err
+	}
+	val := state.GetState(addr, slot)
 	out := &rpcMessage{
 		Version: "2.0",
-		ID:      1,
-		Result:  fmt.Sprintf("0x%x", bal),
+		Result:  hexutil.Bytes(val[:]),
 	}
 	return out, nil
 }
 
+// storageKey decodes a storage position of at most 32 bytes. Quantities like "0x0"
+// are accepted as well as full hashes.
+func storageKey(key string) (common.Hash, error) {
+	if !strings.HasPrefix(key, "0x") && !strings.HasPrefix(key, "0X") {
+		return common.Hash{}, fmt.Errorf("invalid storage key %q: missing 0x prefix", key)
+	}
+	digits := key[2:]
+	if len(digits) == 0 {
+		return common.Hash{}, fmt.Errorf("invalid storage key %q: empty", key)
+	}
+	if len(digits)%2 == 1 {
+		digits = "0" + digits
+	}
+	b, err := hexutil.Decode("0x" + digits)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("invalid storage key %q: %s", key, err)
+	}
+	if len(b) > common.HashLength {
+		return common.Hash{}, fmt.Errorf("invalid storage key %q: longer than %d bytes", key, common.HashLength)
+	}
+	return common.BytesToHash(b), nil
+}
+
 // func getNonce(eth *thereum.Thereum, msg *rpcMessage) (*rpcMessage, error) {
 // 	return
 // }
 
-// func (p *sendTxParams) UnmarshalJSON(in []byte) error {
-// 	type params struct {
-// 		From     string `json:"from"`
-// 		To       string `json:"to"`
-// 		Gas      string `json:"gas"`
-// 		GasPrice string `json:"gasPrice"`
-// 		Value    string `json:"value"`
-// 		Data     string `json:"data"`
-// 	}
-// 	var data params
-// 	err := json.Unmarshal(in, &data)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	p.From = common.HexToAddress(data.From)
-// 	p.To = common.HexToAddress(data.To)
-// 	p.Gas, err = strconv.ParseUint(data.To, 10, 64)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	// REMOVE '0x'
-// 	_, ok := p.Value.SetString(data.Value, 16)
-// 	if !ok {
-// 		return fmt.Errorf("could not parse big integer")
-// 	}
-
-// 	return nil
-// }
-
 // func chainID(eth *thereum.Thereum, msg rpcMessage) (*rpcMessage, error) {
 // 	out := &rpcMessage{
 // 		Version: "2.0",
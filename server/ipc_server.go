@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ipcWriteWait is the time allowed to write a message to the client
+const ipcWriteWait = 10 * time.Second
+
+// ServeIPC serves ethereum json rpc over the unix socket at path, using newline
+// delimited json like geth.ipc. Only the current user can connect, so callers are
+// admins. The socket is removed once the server's context is
+// done, after which ServeIPC returns nil.
+func (s *Server) ServeIPC(path string) error {
+	lstnr, err := listenIPC(path)
+	if err != nil {
+		return err
+	}
+	// closing a unix listener also removes its socket
+	defer lstnr.Close()
+	go func() {
+		<-s.ctx.Done()
+		lstnr.Close()
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := lstnr.Accept()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newIPCSession(s, conn).run()
+		}()
+	}
+}
+
+// listenIPC creates the unix socket, replacing any socket left over from a previous
+// run. Only the current user can connect.
+func listenIPC(path string) (net.Listener, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0751)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.Mode()&os.ModeSocket == 0:
+		return nil, fmt.Errorf("could not serve ipc: %s exists and is not a socket", path)
+	case err == nil:
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	lstnr, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		lstnr.Close()
+		return nil, err
+	}
+	return lstnr, nil
+}
+
+////////////////////////////////
+//	IPC Sessions
+//////////////////////////////
+
+// ipcSession serves a session over a unix socket connection
+type ipcSession struct {
+	*session
+	conn net.Conn
+}
+
+func newIPCSession(s *Server, conn net.Conn) *ipcSession {
+	return &ipcSession{
+		session: newSession(s, adminPermission),
+		conn:    conn,
+	}
+}
+
+// run serves the session until the client leaves or the server shuts down. Every
+// subscription is cancelled before returning.
+func (sess *ipcSession) run() {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sess.writeLoop()
+	}()
+	sess.readLoop()
+	sess.cancel()
+	wg.Wait()
+	sess.conn.Close()
+}
+
+// readLoop handles each json value sent by the client until the connection fails
+func (sess *ipcSession) readLoop() {
+	defer sess.startFeeds()
+	dec := json.NewDecoder(bufio.NewReader(sess.conn))
+	for {
+		var body json.RawMessage
+		err := dec.Decode(&body)
+		if err != nil {
+			// the stream can't be recovered after invalid json, so the session ends
+			if _, ok := err.(*json.SyntaxError); ok {
+				sess.write(rpcError(nil, parseErrorCode, fmt.Sprintf("could not parse request: %s", err)))
+			}
+			return
+		}
+		if sess.handle(body) != nil {
+			return
+		}
+	}
+}
+
+// writeLoop writes queued messages, each followed by a newline. Once the session
+// ends, messages still queued are flushed and the connection is closed, which also
+// stops the read loop.
+func (sess *ipcSession) writeLoop() {
+	defer sess.conn.Close()
+	for {
+		select {
+		case <-sess.ctx.Done():
+			for {
+				select {
+				case msg := <-sess.send:
+					if sess.writeMessage(msg) != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case msg := <-sess.send:
+			err := sess.writeMessage(msg)
+			if err != nil {
+				log.Println("ipc write error:", err)
+				sess.cancel()
+				return
+			}
+		}
+	}
+}
+
+func (sess *ipcSession) writeMessage(msg []byte) error {
+	sess.conn.SetWriteDeadline(time.Now().Add(ipcWriteWait))
+	_, err := sess.conn.Write(append(msg, '\n'))
+	return err
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/evan-forbes/ethlab/thereum"
+)
+
+////////////////////////////////
+//	Recording Traffic
+//////////////////////////////
+
+// Recording is a single request, or batch of requests, recorded along with the
+// server's response. Notifications don't have a response.
+type Recording struct {
+	Kind     string          `json:"kind,omitempty"` // Kind is empty for json rpc requests, or "faucet" for eth sent by the faucet
+	Time     time.Time       `json:"time"`
+	Block    uint64          `json:"block"`   // Block is the latest block number when the request was received
+	Pending  uint64          `json:"pending"` // Pending is the number of the pending block, which is ahead of Block while it's being added
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// faucetRecording is the kind of recording made when the faucet sends eth. Faucet
+// requests aren't json rpc, but they change the chain, so replays must send the same
+// transactions.
+const faucetRecording = "faucet"
+
+// RecordingHeader is the first line of a recording, holding what's needed to boot a
+// chain the recording can be replayed on
+type RecordingHeader struct {
+	Seed string `json:"seed"` // Seed is the seed of the recorded chain's config
+}
+
+// headerLine wraps the header, so it can't be mistaken for a recording
+type headerLine struct {
+	Header *RecordingHeader `json:"header"`
+}
+
+// Recorder writes each recording as a line of json
+type Recorder struct {
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+// NewRecorder issues a Recorder that writes to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Record writes a single recording
+func (r *Recorder) Record(rec Recording) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(rec)
+}
+
+// WriteHeader writes the header, which must come before any recordings
+func (r *Recorder) WriteHeader(h RecordingHeader) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(headerLine{Header: &h})
+}
+
+// Record records every request handled by the server, over any transport, using rec
+func (s *Server) Record(rec *Recorder) {
+	s.recorder = rec
+}
+
+// handle processes a request body using call, recording the request and response
+// if the server is recording
+func (s *Server) handle(body []byte, call caller) []byte {
+	if s.recorder == nil {
+		return handleBody(body, call)
+	}
+	return s.recordHeld(Recording{Request: recordable(body)}, func() []byte {
+		return handleBody(body, call)
+	})
+}
+
+// recordHeld runs f while the chain is held still, so the recorded blocks are the ones
+// f saw, and records the response f returns
+func (s *Server) recordHeld(rec Recording, f func() []byte) []byte {
+	var out []byte
+	s.back.Hold(func() {
+		rec.Time = time.Now()
+		rec.Block = s.back.LatestBlock().NumberU64()
+		rec.Pending = s.back.PendingBlock().NumberU64()
+		out = f()
+	})
+	rec.Response = out
+	err := s.recorder.Record(rec)
+	if err != nil {
+		log.Println("failed to record request:", err)
+	}
+	return out
+}
+
+// faucetSent is the recorded response of the faucet sending eth
+type faucetSent struct {
+	TxHash *common.Hash `json:"tx_hash,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// sendFaucetTx sends a transaction for the faucet, recording it if the server is
+// recording
+func (s *Server) sendFaucetTx(args thereum.TxArgs) (*types.Transaction, error) {
+	if s.recorder == nil {
+		return s.back.SendTxArgs(args)
+	}
+	req, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	var tx *types.Transaction
+	s.recordHeld(Recording{Kind: faucetRecording, Request: req}, func() []byte {
+		tx, err = s.back.SendTxArgs(args)
+		return faucetResponse(tx, err)
+	})
+	return tx, err
+}
+
+// faucetResponse encodes the result of sending a faucet transaction
+func faucetResponse(tx *types.Transaction, err error) []byte {
+	var sent faucetSent
+	if err != nil {
+		sent.Error = err.Error()
+	} else {
+		hash := tx.Hash()
+		sent.TxHash = &hash
+	}
+	out, _ := json.Marshal(sent)
+	return out
+}
+
+// recordable returns the body as raw json, or as a json string if it isn't valid json
+func recordable(body []byte) json.RawMessage {
+	if json.Valid(body) {
+		return body
+	}
+	out, _ := json.Marshal(string(body))
+	return out
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/evan-forbes/ethlab/thereum"
+)
+
+////////////////////////////////
+//	Replaying Traffic
+//////////////////////////////
+
+// Mismatch is a replayed response that differs from the recorded one
+type Mismatch struct {
+	Line     int             `json:"line"` // Line is the line of the recording, starting at 1
+	Method   string          `json:"method"`
+	Recorded json.RawMessage `json:"recorded"`
+	Replayed json.RawMessage `json:"replayed"`
+}
+
+// maxRecordingLine is the longest line that can be read from a recording
+const maxRecordingLine = 64 * 1024 * 1024
+
+// idMethods create filters or subscriptions, whose ids are random
+var idMethods = map[string]bool{
+	"eth_newFilter":                   true,
+	"eth_newBlockFilter":              true,
+	"eth_newPendingTransactionFilter": true,
+	"eth_subscribe":                   true,
+}
+
+// idParamMethods take the id of a filter or subscription as their first parameter
+var idParamMethods = map[string]bool{
+	"eth_getFilterChanges": true,
+	"eth_getFilterLogs":    true,
+	"eth_uninstallFilter":  true,
+	"eth_unsubscribe":      true,
+}
+
+// Replay handles each recorded request in order, reporting every response that
+// differs from the recording. Before each request, the chain is stepped through until
+// it's at the block the request was recorded at, so the backend must not be running,
+// and must be booted using the same config and seed as the recorded one, which is
+// found using ReadRecordingHeader.
+// Filter and subscription ids are mapped to the ones issued during the replay, and
+// subscription notifications are ignored. Eth sent by the faucet is sent again, while
+// the faucet's waiting for receipts is left to the requests that follow.
+func (s *Server) Replay(r io.Reader) ([]Mismatch, error) {
+	sess := newSession(s, adminPermission)
+	defer sess.cancel()
+	// notifications aren't recorded
+	go func() {
+		for {
+			select {
+			case <-sess.send:
+			case <-sess.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mismatches []Mismatch
+	ids := make(map[string]string) // recorded id -> replayed id
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordingLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if line == 1 && parseHeader(scanner.Bytes()) != nil {
+			continue
+		}
+		var rec Recording
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return mismatches, fmt.Errorf("could not parse line %d of the recording: %s", line, err)
+		}
+		s.advance(rec)
+		if rec.Kind == faucetRecording {
+			m, err := s.replayFaucet(rec)
+			if err != nil {
+				return mismatches, fmt.Errorf("could not replay line %d of the recording: %s", line, err)
+			}
+			if m != nil {
+				m.Line = line
+				mismatches = append(mismatches, *m)
+			}
+			continue
+		}
+
+		body := replayable(rec.Request)
+		reqs, batch := splitBody(body)
+		for i, req := range reqs {
+			reqs[i] = replaceID(req, ids)
+		}
+		switch {
+		case batch:
+			body, err = json.Marshal(reqs)
+			if err != nil {
+				return mismatches, err
+			}
+		case len(reqs) == 1:
+			body = reqs[0]
+		}
+		out := handleBody(body, sess.call)
+		sess.startFeeds()
+
+		// pair each response with the method that was called
+		recorded, _ := splitBody(rec.Response)
+		replayed, _ := splitBody(out)
+		methods := responseMethods(reqs)
+		if len(recorded) != len(replayed) || len(recorded) != len(methods) {
+			mismatches = append(mismatches, Mismatch{Line: line, Recorded: rec.Response, Replayed: out})
+			continue
+		}
+		for i, method := range methods {
+			if idMethods[method] && mapID(recorded[i], replayed[i], ids) {
+				continue
+			}
+			if !jsonEqual(recorded[i], replayed[i]) {
+				mismatches = append(mismatches, Mismatch{
+					Line:     line,
+					Method:   method,
+					Recorded: recorded[i],
+					Replayed: replayed[i],
+				})
+			}
+		}
+	}
+	return mismatches, scanner.Err()
+}
+
+// ReadRecordingHeader reads the header from the first line of a recording, returning
+// nil if the recording doesn't have one
+func ReadRecordingHeader(r io.Reader) (*RecordingHeader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordingLine)
+	if !scanner.Scan() {
+		return nil, scanner.Err()
+	}
+	return parseHeader(scanner.Bytes()), nil
+}
+
+func parseHeader(line []byte) *RecordingHeader {
+	var h headerLine
+	if json.Unmarshal(line, &h) != nil {
+		return nil
+	}
+	return h.Header
+}
+
+// advance steps through the chain until it's at the recorded block, preparing the
+// pending block ahead of it if it was being added when recorded
+func (s *Server) advance(rec Recording) {
+	for s.back.LatestBlock().NumberU64() < rec.Block {
+		if s.back.Prepare() == nil {
+			return
+		}
+		s.back.AppendPending()
+	}
+	if rec.Pending > rec.Block {
+		s.back.Prepare()
+	}
+}
+
+// replayFaucet sends the transaction the faucet sent when recorded, reporting a
+// mismatch if the result differs
+func (s *Server) replayFaucet(rec Recording) (*Mismatch, error) {
+	var args thereum.TxArgs
+	err := json.Unmarshal(rec.Request, &args)
+	if err != nil {
+		return nil, err
+	}
+	out := faucetResponse(s.back.SendTxArgs(args))
+	if jsonEqual(rec.Response, out) {
+		return nil, nil
+	}
+	return &Mismatch{Method: "requestETH", Recorded: rec.Response, Replayed: out}, nil
+}
+
+// replayable undoes recordable, returning the original body of the request
+func replayable(raw json.RawMessage) []byte {
+	var body string
+	if json.Unmarshal(raw, &body) == nil {
+		return []byte(body)
+	}
+	return raw
+}
+
+// splitBody splits a batch into its messages, reporting if it was a batch
+func splitBody(body []byte) ([]json.RawMessage, bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, false
+	}
+	if body[0] == '[' {
+		var batch []json.RawMessage
+		if json.Unmarshal(body, &batch) == nil {
+			return batch, true
+		}
+	}
+	return []json.RawMessage{body}, false
+}
+
+// responseMethods returns the method of each request that gets a response
+func responseMethods(reqs []json.RawMessage) []string {
+	var out []string
+	for _, raw := range reqs {
+		var req rpcMessage
+		if json.Unmarshal(raw, &req) == nil && req.isNotification() {
+			continue
+		}
+		out = append(out, req.Method)
+	}
+	return out
+}
+
+// replaceID replaces the recorded filter or subscription id used by a request with
+// the one issued during the replay
+func replaceID(raw json.RawMessage, ids map[string]string) json.RawMessage {
+	var req rpcMessage
+	if json.Unmarshal(raw, &req) != nil || !idParamMethods[req.Method] {
+		return raw
+	}
+	var params []json.RawMessage
+	if json.Unmarshal(req.Params, &params) != nil || len(params) == 0 {
+		return raw
+	}
+	var id string
+	if json.Unmarshal(params[0], &id) != nil {
+		return raw
+	}
+	replayed, has := ids[id]
+	if !has {
+		return raw
+	}
+	params[0], _ = json.Marshal(replayed)
+	req.Params, _ = json.Marshal(params)
+	out, err := json.Marshal(req)
+	if err != nil {
+		return raw
+	}
+	return out
+}
+
+// mapID maps the id created in a recorded response to the one created in the replayed
+// response, reporting if both responses created an id
+func mapID(recorded, replayed json.RawMessage, ids map[string]string) bool {
+	var rec, rep struct {
+		Result string `json:"result"`
+	}
+	if json.Unmarshal(recorded, &rec) != nil || json.Unmarshal(replayed, &rep) != nil {
+		return false
+	}
+	if rec.Result == "" || rep.Result == "" {
+		return false
+	}
+	ids[rec.Result] = rep.Result
+	return true
+}
+
+// jsonEqual reports if a and b encode the same json value
+func jsonEqual(a, b json.RawMessage) bool {
+	var av, bv interface{}
+	if decodeNumbers(a, &av) != nil || decodeNumbers(b, &bv) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+func decodeNumbers(raw json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
@@ -1,12 +1,14 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/big"
+	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -19,11 +21,6 @@ import (
 	"github.com/pkg/errors"
 )
 
-/* TODO:
-- handle errors during streaming
-- implement the ability to unsubscribe cleanly
-*/
-
 ////////////////////////////////
 // 		RPC Server
 //////////////////////////////
@@ -32,11 +29,14 @@ import (
 // the standardized ethereum json rpc.
 type Server struct {
 	http.Server
-	router *mux.Router      // handles api endpoints
-	muxer  *muxer           // connects msg to procedure
-	back   *thereum.Thereum // backend to serve
-	ctx    context.Context
-	ens    common.Address
+	router   *mux.Router      // handles api endpoints
+	muxer    *muxer           // connects msg to procedure
+	back     *thereum.Thereum // backend to serve
+	ctx      context.Context
+	ens      common.Address
+	recorder *Recorder // recorder optionally records every request and response
+	policy   *policy   // policy decides which origins and callers can use the server
+	faucet   *faucetPolicy
 }
 
 // LaunchServer creates a new thereum backend with sane defaults and a launched
@@ -50,7 +50,7 @@ func LaunchServer(ctx context.Context, wg *sync.WaitGroup) (*Server, error) {
 	wg.Add(1)
 	go eth.Run(ctx, wg)
 
-	// start serving the backend via http and ws
+	// start serving the backend via http and ws, with ws also on its own port
 	srvr := NewServer(ctx, "127.0.0.1:8000", eth)
 	go func() {
 		log.Fatal(srvr.ListenAndServe())
@@ -69,12 +69,15 @@ func LaunchServer(ctx context.Context, wg *sync.WaitGroup) (*Server, error) {
 }
 
 // NewServer issues a new server with the rpc handler already registered
+// writeTimeout is how long a response to an http request has to be written
+const writeTimeout = 20 * time.Second
+
 func NewServer(ctx context.Context, addr string, back *thereum.Thereum) *Server {
 	rtr := mux.NewRouter()
 	srv := &Server{
 		Server: http.Server{
 			Addr:         addr,
-			WriteTimeout: time.Second * 20, // TODO: read from config
+			WriteTimeout: writeTimeout, // TODO: read from config
 			ReadTimeout:  time.Second * 10,
 			IdleTimeout:  time.Second * 100,
 			Handler:      rtr,
@@ -84,9 +87,15 @@ func NewServer(ctx context.Context, addr string, back *thereum.Thereum) *Server
 		muxer:  newMuxer(),
 		ctx:    ctx,
 	}
+	// anyone can use any method, and the faucet is unlimited, until configured
+	srv.policy, _ = newPolicy(thereum.AuthConfig{})
+	srv.faucet, _ = newFaucetPolicy(thereum.FaucetConfig{})
+	srv.router.Use(srv.authorize)
+	// websocket upgrades are served on the same route as http requests
+	srv.router.Handle("/", srv.wsHandler()).MatcherFunc(isWebsocket)
 	// install the universal rpc handler to the router
 	srv.router.HandleFunc("/", srv.rpcHandler())
-	srv.router.HandleFunc("/requestETH", srv.faucetHandler())
+	srv.router.Handle("/requestETH", srv.require("faucet", srv.faucetHandler()))
 
 	srv.router.HandleFunc("/ens", srv.ENSHandler)
 	srv.back = back
@@ -103,51 +112,101 @@ func (s *Server) rpcHandler() http.HandlerFunc {
 		body, err := ioutil.ReadAll(r.Body)
 		defer r.Body.Close()
 		if err != nil {
-			resp := rpcError(500, fmt.Sprintf("could not read request: %s", string(body)))
-			w.Write(resp)
-			return
-		}
-
-		// unmarshal the rpc request
-		var req rpcMessage
-		err = json.Unmarshal(body, &req)
-		if err != nil {
-			// send an error back if rpcMessage cannot be unmarshaled
-			w.Write(rpcError(500, fmt.Sprintf("could not unmarshal rpc message: %s", err)))
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(rpcError(nil, invalidRequestCode, fmt.Sprintf("could not read request: %s", err)))
 			return
 		}
 
-		fmt.Println("method", req.Method)
-
-		// use the method's procdure to perform the remote procedure call
-		pro, has := s.muxer.Route(req.Method)
-		if !has {
-			log.Println("no procedure for method: ", req.Method)
-			w.Write(rpcError(0, fmt.Sprintf("method %s not supported", req.Method)))
+		// requests made up of only notifications don't get a response
+		out := s.handle(body, s.authorized(roleOf(r), s.call))
+		if out == nil {
 			return
 		}
-		resp, err := pro(s.back, &req)
+		_, err = w.Write(out)
 		if err != nil {
-			w.Write(rpcError(500, fmt.Sprintf("error calling %s: %s", req.Method, err)))
-			return
+			log.Println("failed to write rpc response:", err)
 		}
+	}
+}
 
-		// marshal the processed response
-		out, err := json.Marshal(resp)
-		if err != nil {
-			w.Write(rpcError(500, fmt.Sprintf("interanl marshaling error calling %s: %s", req.Method, err)))
-			log.Println(err)
-			log.Printf("failed to marshal response from %s procedure: %+v\n", req.Method, resp)
+// caller performs a single remote procedure call, returning the response
+type caller func(req *rpcMessage) *rpcMessage
+
+// handleBody processes a single request or a batch of requests using call, returning
+// the marshaled response(s)
+func handleBody(body []byte, call caller) []byte {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		return handleMessage(body, call)
+	}
+	var batch []json.RawMessage
+	err := json.Unmarshal(body, &batch)
+	if err != nil {
+		return rpcError(nil, parseErrorCode, fmt.Sprintf("could not parse batch: %s", err))
+	}
+	if len(batch) == 0 {
+		return rpcError(nil, invalidRequestCode, "empty batch")
+	}
+	var resps []json.RawMessage
+	for _, raw := range batch {
+		resp := handleMessage(raw, call)
+		if resp != nil {
+			resps = append(resps, resp)
 		}
+	}
+	if len(resps) == 0 {
+		return nil
+	}
+	out, err := json.Marshal(resps)
+	if err != nil {
+		return rpcError(nil, internalErrorCode, fmt.Sprintf("could not marshal batch: %s", err))
+	}
+	return out
+}
 
-		// write the response to the client
-		_, err = w.Write(out)
-		if err != nil {
-			w.Write(rpcError(500, fmt.Sprintf("interanl writing error calling %s: %s", req.Method, err)))
-			log.Println(err)
-			return
+// handleMessage processes a single request, returning nil for notifications
+func handleMessage(raw json.RawMessage, call caller) []byte {
+	var req rpcMessage
+	err := json.Unmarshal(raw, &req)
+	if err != nil {
+		// valid json that isn't a request object is an invalid request
+		if json.Valid(raw) {
+			return rpcError(nil, invalidRequestCode, fmt.Sprintf("invalid request: %s", err))
 		}
+		return rpcError(nil, parseErrorCode, fmt.Sprintf("could not parse request: %s", err))
+	}
+	if req.Version != "2.0" || req.Method == "" {
+		return rpcError(req.ID, invalidRequestCode, "invalid request: jsonrpc must be \"2.0\" and method must be set")
 	}
+	resp := call(&req)
+	if req.isNotification() {
+		return nil
+	}
+	out, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("failed to marshal response from %s procedure: %+v\n", req.Method, resp)
+		return rpcError(req.ID, internalErrorCode, fmt.Sprintf("internal marshaling error calling %s: %s", req.Method, err))
+	}
+	return out
+}
+
+// call uses the method's procedure to perform the remote procedure call, echoing the
+// request's ID in the response
+func (s *Server) call(req *rpcMessage) *rpcMessage {
+	pro, has := s.muxer.Route(req.Method)
+	if !has {
+		log.Println("no procedure for method: ", req.Method)
+		return errorMessage(req.ID, methodNotFoundCode, fmt.Sprintf("the method %s does not exist/is not available", req.Method))
+	}
+	resp, err := pro(s.back, req)
+	if err != nil {
+		return errorMessage(req.ID, errorCode(err), err.Error())
+	}
+	if resp == nil {
+		resp = &rpcMessage{Version: "2.0"}
+	}
+	resp.ID = req.ID
+	return resp
 }
 
 ////////////////////////////////
@@ -167,20 +226,45 @@ func newMuxer() *muxer {
 	return &muxer{
 		routes: map[string]procedure{
 			// add rpc methods here!
-			"":                          nullProcedure,
-			"eth_chainId":               nullProcedure,
-			"eth_protocolVersion":       nullProcedure,
-			"eth_gasPrice":              nullProcedure,
-			"eth_blockNumber":           nullProcedure,
-			"eth_getBalance":            getBalanceAt,
-			"eth_getStorageAt":          nullProcedure,
-			"eth_sendTransaction":       sendRawTx, // account management shouldn't really be a feature
-			"eth_sendRawTransaction":    sendRawTx,
-			"eth_getTransactionReceipt": getTxReceipt,
-			"eth_getTransactionCount":   getTxCount,
-			"eth_call":                  nullProcedure,
-			"eth_getLogs":               nullProcedure,
-			"eth_getFilterLogs":         nullProcedure,
+			"":                                   nullProcedure,
+			"eth_chainId":                        nullProcedure,
+			"eth_protocolVersion":                nullProcedure,
+			"eth_gasPrice":                       nullProcedure,
+			"eth_blockNumber":                    nullProcedure,
+			"eth_getBalance":                     getBalanceAt,
+			"eth_getStorageAt":                   getStorageAt,
+			"eth_getCode":                        getCode,
+			"eth_getBlockByNumber":               getBlockByNumber,
+			"eth_getBlockByHash":                 getBlockByHash,
+			"eth_getBlockTransactionCountByHash": getBlockTxCountByHash,
+			"eth_getTransactionByHash":           getTxByHash,
+			"eth_getTransactionByBlockNumberAndIndex": getTxByBlockNumberAndIndex,
+			"eth_accounts":                    getAccounts,
+			"eth_sendTransaction":             sendTx,
+			"eth_signTransaction":             signTx,
+			"eth_sign":                        sign,
+			"personal_sign":                   personalSign,
+			"eth_signTypedData_v4":            signTypedData,
+			"eth_sendRawTransaction":          sendRawTx,
+			"eth_sendBundle":                  sendBundle,
+			"eth_getTransactionReceipt":       getTxReceipt,
+			"eth_getTransactionCount":         getTxCount,
+			"eth_call":                        nullProcedure,
+			"eth_getLogs":                     getLogs,
+			"eth_newFilter":                   newFilter,
+			"eth_newBlockFilter":              newBlockFilter,
+			"eth_newPendingTransactionFilter": newPendingTxFilter,
+			"eth_getFilterChanges":            getFilterChanges,
+			"eth_getFilterLogs":               getFilterLogs,
+			"eth_uninstallFilter":             uninstallFilter,
+			// ethlab specific methods
+			"ethlab_scheduleTransaction":        scheduleTx,
+			"ethlab_cancelScheduledTransaction": cancelScheduledTx,
+			"ethlab_scheduledTransactions":      listScheduledTxs,
+			// txpool namespace
+			"txpool_content": txPoolContent,
+			"txpool_status":  txPoolStatus,
+			"txpool_inspect": txPoolInspect,
 		},
 	}
 }
@@ -196,41 +280,104 @@ func (m *muxer) Route(method string) (procedure, bool) {
 // 		RPC Messaging
 //////////////////////////////
 
+// standard json rpc error codes
+const (
+	parseErrorCode     = -32700
+	invalidRequestCode = -32600
+	methodNotFoundCode = -32601
+	invalidParamsCode  = -32602
+	internalErrorCode  = -32603
+	executionErrorCode = -32000 // used for any error returned by a procedure
+	unauthorizedCode   = -32001 // used when the caller isn't allowed to make the request
+)
+
 // A value of this type can be a JSON-RPC request, notification, successful response or
 // error response. Which one it is depends on the fields.
 type rpcMessage struct {
 	Version string          `json:"jsonrpc,omitempty"`
-	ID      int             `json:"id,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"` // ID is kept verbatim so it can be echoed
 	Method  string          `json:"method,omitempty"`
 	Params  json.RawMessage `json:"params,omitempty"`
 	Error   *jsonError      `json:"error,omitempty"`
 	Result  interface{}     `json:"result,omitempty"`
 }
 
+// isNotification reports if the message is a request that doesn't want a response
+func (msg *rpcMessage) isNotification() bool {
+	return len(msg.ID) == 0 && msg.Method != ""
+}
+
+type rpcResult struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type rpcFailure struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *jsonError      `json:"error"`
+}
+
+// MarshalJSON implements json.Marshaler. Responses always include their ID, and
+// either a result, which can be null, or an error.
+func (msg rpcMessage) MarshalJSON() ([]byte, error) {
+	if msg.Method != "" {
+		type request rpcMessage
+		return json.Marshal(request(msg))
+	}
+	if msg.Error != nil {
+		return json.Marshal(rpcFailure{Version: "2.0", ID: msg.ID, Error: msg.Error})
+	}
+	return json.Marshal(rpcResult{Version: "2.0", ID: msg.ID, Result: msg.Result})
+}
+
 type jsonError struct {
 	Code    int         `json:"code"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
 }
 
-func (s *Server) baseRPCMessage() rpcMessage {
-	return rpcMessage{
-		Version: "2.0",
-		ID:      1,
+// codedError is an error returned by a procedure with a specific json rpc error code
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+
+// invalidParams marks an error as being caused by the parameters of a request
+func invalidParams(err error) error {
+	if err == nil {
+		return nil
 	}
+	return &codedError{code: invalidParamsCode, err: err}
 }
 
-func rpcError(code int, msg string) []byte {
-	out, _ := json.Marshal(
-		rpcMessage{
-			Version: "2.0",
-			ID:      1,
-			Error: &jsonError{
-				Code:    code,
-				Message: msg,
-			},
+// errorCode finds the json rpc error code for an error returned by a procedure
+func errorCode(err error) int {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return executionErrorCode
+}
+
+// errorMessage creates an error response
+func errorMessage(id json.RawMessage, code int, msg string) *rpcMessage {
+	return &rpcMessage{
+		Version: "2.0",
+		ID:      id,
+		Error: &jsonError{
+			Code:    code,
+			Message: msg,
 		},
-	)
+	}
+}
+
+// rpcError creates a marshaled error response
+func rpcError(id json.RawMessage, code int, msg string) []byte {
+	out, _ := json.Marshal(errorMessage(id, code, msg))
 	return out
 }
 
@@ -268,24 +415,20 @@ func (s *Server) ENSHandler(w http.ResponseWriter, r *http.Request) {
 //	Faucet
 //////////////////////////////
 
-// faucetHandler issues initial ETH to the address provided in the request
+// faucetHandler issues initial ETH to the address provided in the request, as long as
+// the request qualifies under the faucet's policy
 func (s *Server) faucetHandler() http.HandlerFunc {
 	type faucetPay struct {
 		Address string   `json:"address"`
 		Amount  *big.Int `json:"amount"`
 	}
 
-	type faucetResp struct {
-		Message string `json:"message"`
-	}
-
 	return func(w http.ResponseWriter, r *http.Request) {
 		// read the body of the request
 		body, err := ioutil.ReadAll(r.Body)
 		defer r.Body.Close()
 		if err != nil {
-			resp, _ := json.Marshal(faucetResp{Message: err.Error()})
-			w.Write(resp)
+			writeFaucetError(w, refuse(http.StatusBadRequest, "bad_request", "could not read request: %s", err))
 			return
 		}
 
@@ -293,44 +436,52 @@ func (s *Server) faucetHandler() http.HandlerFunc {
 		var pay faucetPay
 		err = json.Unmarshal(body, &pay)
 		if err != nil {
-			resp, _ := json.Marshal(faucetResp{Message: err.Error()})
-			w.Write(resp)
+			writeFaucetError(w, refuse(http.StatusBadRequest, "bad_request", "could not parse request: %s", err))
 			return
 		}
-		// TODO: check if the recipient qualifies for free eth
-		// use the root account to send some eth
-		root := s.back.Accounts["root"]
-		// root.IncrNonce()
-		tx, err := root.CreateSend(common.HexToAddress(pay.Address), pay.Amount)
-		if err != nil {
-			log.Printf("could not send one ETH upon request to %s: %s\n", pay.Address, err.Error())
+		if !common.IsHexAddress(pay.Address) {
+			writeFaucetError(w, refuse(http.StatusBadRequest, "bad_request", "invalid address %q", pay.Address))
 			return
 		}
-		// validate and add the transaction to txPool
-		err = s.back.AddTx(tx)
-		if err != nil {
-			log.Printf("could not send one ETH upon request to %s: %s failure to add transaction\n", pay.Address, err.Error())
+		if pay.Amount == nil || pay.Amount.Sign() <= 0 {
+			writeFaucetError(w, refuse(http.StatusBadRequest, "bad_request", "amount must be a positive amount of wei"))
 			return
 		}
-		// wait a second and see if the amount requested actually got through
-		time.Sleep(time.Millisecond * 2 * time.Duration(s.back.Delay))
+		addr := common.HexToAddress(pay.Address)
 
-		rcpt, err := s.back.TxReceipt(tx.Hash())
+		// check if the recipient qualifies for free eth
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
-			fmt.Println("could not get transaction receipt for sent eth:", err)
+			ip = r.RemoteAddr
 		}
-		if rcpt == nil {
-			fmt.Println("could not get transaction receipt for sent eth:", err)
-
+		undo, err := s.faucet.reserve(addr, ip, pay.Amount)
+		if err != nil {
+			writeFaucetError(w, err)
+			return
 		}
 
-		resp, _ := json.Marshal(faucetResp{Message: "success"})
+		// send the eth, and wait until it's mined
+		rcpt, balance, err := s.drip(r.Context(), addr, pay.Amount)
+		if err != nil {
+			// eth that might still be sent keeps counting against the limits
+			if refused, ok := err.(*faucetError); !ok || !refused.pending {
+				undo()
+			}
+			log.Printf("could not send ETH upon request to %s: %s\n", pay.Address, err.Error())
+			writeFaucetError(w, err)
+			return
+		}
+		resp, _ := json.Marshal(faucetResp{
+			Message:     "success",
+			TxHash:      &rcpt.TxHash,
+			BlockNumber: rcpt.BlockNumber.Uint64(),
+			Balance:     balance,
+		})
+		w.Header().Set("content-type", "application/json")
 		_, err = w.Write(resp)
 		if err != nil {
 			log.Println("failure to respond with successful faucet response:", err)
-			return
 		}
-		return
 	}
 }
 
@@ -1,20 +1,38 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/evan-forbes/ethlab/cmd"
 	"github.com/evan-forbes/ethlab/contracts/ens"
+	"github.com/evan-forbes/ethlab/eip712"
 	"github.com/evan-forbes/ethlab/module"
 	"github.com/evan-forbes/ethlab/thereum"
 	"github.com/matryer/is"
@@ -331,7 +349,7 @@ func bootWithUser(t *testing.T) (*module.User, *Server, error) {
 		t.Error(err)
 		return nil, srv, err
 	}
-	err = module.RequestETH("127.0.0.1:8000", usr.From.Hex(), big.NewInt(1000000000000000000))
+	_, err = module.RequestETH("127.0.0.1:8000", usr.From.Hex(), big.NewInt(1000000000000000000))
 	if err != nil {
 		t.Error(err)
 		return nil, srv, err
@@ -388,3 +406,1024 @@ func TestSubscribeLogs(t *testing.T) {
 	time.Sleep(5 * time.Second)
 
 }
+
+// runServer starts a funded chain, and serves it over http and websockets on the
+// provided endpoints. Websockets are only served on endpoint if wsEndpoint is empty.
+func runServer(t *testing.T, endpoint, wsEndpoint string) (*thereum.Thereum, func()) {
+	config := thereum.DefaultConfig()
+	config.Allocation["alice"] = "1000000000000000000000"
+	config.Allocation["bob"] = "1000000000000000000000"
+	eth, err := thereum.New(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go eth.Run(ctx, wg)
+	srvr := NewServer(ctx, endpoint, eth)
+	go srvr.ListenAndServe()
+	if wsEndpoint != "" {
+		go srvr.ServeWS(wsEndpoint)
+	}
+	time.Sleep(100 * time.Millisecond)
+	return eth, func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func TestPendingTxStream(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runServer(t, "127.0.0.1:8020", "127.0.0.1:8021")
+	defer stop()
+	alice, bob := eth.Accounts["alice"], eth.Accounts["bob"]
+
+	hashClient, err := rpc.Dial("ws://127.0.0.1:8021")
+	is.NoErr(err)
+	defer hashClient.Close()
+	hashes := make(chan common.Hash)
+	hashSub, err := hashClient.EthSubscribe(context.Background(), hashes, "newPendingTransactions")
+	is.NoErr(err)
+	defer hashSub.Unsubscribe()
+
+	fullClient, err := rpc.Dial("ws://127.0.0.1:8021")
+	is.NoErr(err)
+	defer fullClient.Close()
+	txs := make(chan *types.Transaction)
+	fullSub, err := fullClient.EthSubscribe(context.Background(), txs, "newPendingTransactions", true)
+	is.NoErr(err)
+	defer fullSub.Unsubscribe()
+	time.Sleep(100 * time.Millisecond)
+
+	tx, err := alice.CreateSend(bob.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(tx))
+	for i := 0; i < 2; i++ {
+		select {
+		case hash := <-hashes:
+			is.Equal(hash, tx.Hash())
+		case full := <-txs:
+			is.Equal(full.Hash(), tx.Hash())
+		case err := <-hashSub.Err():
+			t.Fatal(err)
+		case err := <-fullSub.Err():
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for pending transaction")
+		}
+	}
+}
+
+func TestBlockLookups(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runServer(t, "127.0.0.1:8022", "127.0.0.1:8023")
+	defer stop()
+	alice, bob := eth.Accounts["alice"], eth.Accounts["bob"]
+	client, err := ethclient.Dial("http://127.0.0.1:8022")
+	is.NoErr(err)
+	ctx := context.Background()
+
+	tx, err := alice.CreateSend(bob.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(client.SendTransaction(ctx, tx))
+	var receipt *types.Receipt
+	for i := 0; i < 100 && receipt == nil; i++ {
+		time.Sleep(50 * time.Millisecond)
+		receipt, _ = eth.TxReceipt(tx.Hash())
+	}
+	is.True(receipt != nil)
+
+	block, err := client.BlockByNumber(ctx, receipt.BlockNumber)
+	is.NoErr(err)
+	is.Equal(block.Hash(), receipt.BlockHash)
+	is.Equal(block.Transactions()[receipt.TransactionIndex].Hash(), tx.Hash())
+	head, err := client.HeaderByHash(ctx, receipt.BlockHash)
+	is.NoErr(err)
+	is.Equal(head.Hash(), receipt.BlockHash)
+	latest, err := client.HeaderByNumber(ctx, nil)
+	is.NoErr(err)
+	is.True(latest.Number.Cmp(receipt.BlockNumber) >= 0)
+
+	found, pending, err := client.TransactionByHash(ctx, tx.Hash())
+	is.NoErr(err)
+	is.True(!pending)
+	is.Equal(found.Hash(), tx.Hash())
+	count, err := client.TransactionCount(ctx, receipt.BlockHash)
+	is.NoErr(err)
+	is.Equal(count, uint(len(block.Transactions())))
+	code, err := client.CodeAt(ctx, bob.Address, nil)
+	is.NoErr(err)
+	is.Equal(len(code), 0)
+	val, err := client.StorageAt(ctx, bob.Address, common.Hash{}, nil)
+	is.NoErr(err)
+	is.Equal(val, make([]byte, 32))
+}
+
+func TestStorageKey(t *testing.T) {
+	is := is.New(t)
+	key, err := storageKey("0x0")
+	is.NoErr(err)
+	is.Equal(key, common.Hash{})
+	key, err = storageKey("0x102")
+	is.NoErr(err)
+	is.Equal(key, common.BigToHash(big.NewInt(0x102)))
+	full := crypto.Keccak256Hash([]byte("slot"))
+	key, err = storageKey(full.Hex())
+	is.NoErr(err)
+	is.Equal(key, full)
+	for _, bad := range []string{"", "0x", "12", "0xzz", "0x" + strings.Repeat("ff", 33)} {
+		_, err = storageKey(bad)
+		is.True(err != nil)
+	}
+
+	// invalid keys are reported as invalid params
+	msg := &rpcMessage{Params: json.RawMessage(`["0x295a70b2de5e3953354a6a8344e616ed314d7251", "0xnope"]`)}
+	_, err = getStorageAt(nil, msg)
+	is.Equal(errorCode(err), invalidParamsCode)
+}
+
+func TestBlockParameters(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runServer(t, "127.0.0.1:8024", "127.0.0.1:8025")
+	defer stop()
+	alice, bob := eth.Accounts["alice"], eth.Accounts["bob"]
+	client, err := rpc.Dial("http://127.0.0.1:8024")
+	is.NoErr(err)
+	ethc := ethclient.NewClient(client)
+	ctx := context.Background()
+
+	before, err := ethc.HeaderByNumber(ctx, nil)
+	is.NoErr(err)
+	tx, err := alice.CreateSend(bob.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(ethc.SendTransaction(ctx, tx))
+	var receipt *types.Receipt
+	for i := 0; i < 100 && receipt == nil; i++ {
+		time.Sleep(50 * time.Millisecond)
+		receipt, _ = eth.TxReceipt(tx.Hash())
+	}
+	is.True(receipt != nil)
+
+	// the same balance is returned by number and by hash
+	var byNumber, byHash, latest hexutil.Big
+	is.NoErr(client.Call(&byNumber, "eth_getBalance", bob.Address, hexutil.EncodeBig(before.Number)))
+	is.NoErr(client.Call(&byHash, "eth_getBalance", bob.Address, map[string]interface{}{"blockHash": before.Hash()}))
+	is.NoErr(client.Call(&latest, "eth_getBalance", bob.Address, "latest"))
+	is.Equal(byNumber.ToInt(), byHash.ToInt())
+	is.Equal(new(big.Int).Sub(latest.ToInt(), byNumber.ToInt()), big.NewInt(1))
+
+	var nonce hexutil.Uint64
+	is.NoErr(client.Call(&nonce, "eth_getTransactionCount", alice.Address, "earliest"))
+	is.Equal(uint64(nonce), uint64(0))
+	is.NoErr(client.Call(&nonce, "eth_getTransactionCount", alice.Address, "pending"))
+	is.Equal(uint64(nonce), tx.Nonce()+1)
+
+	// unknown blocks are reported instead of falling back to the latest state
+	err = client.Call(&latest, "eth_getBalance", bob.Address, "0xffffff")
+	is.True(err != nil)
+	err = client.Call(&latest, "eth_getBalance", bob.Address, map[string]interface{}{"blockHash": common.Hash{1}})
+	is.True(err != nil)
+}
+
+func TestPollingFilters(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runServer(t, "127.0.0.1:8026", "127.0.0.1:8027")
+	defer stop()
+	alice := eth.Accounts["alice"]
+	client, err := rpc.Dial("http://127.0.0.1:8026")
+	is.NoErr(err)
+
+	var logFilter, blockFilter string
+	is.NoErr(client.Call(&logFilter, "eth_newFilter", map[string]interface{}{"fromBlock": "0x0"}))
+	is.NoErr(client.Call(&blockFilter, "eth_newBlockFilter"))
+
+	// the constructor emits a log with the topic 0x2a
+	topic := common.BigToHash(big.NewInt(42))
+	deploy, err := alice.Sign(types.NewContractCreation(alice.Nonce.Uint64(), big.NewInt(0), 100000, alice.TxOpts.GasPrice, common.FromHex("0x602a60006000a100")))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(deploy))
+	var receipt *types.Receipt
+	for i := 0; i < 100 && receipt == nil; i++ {
+		time.Sleep(50 * time.Millisecond)
+		receipt, _ = eth.TxReceipt(deploy.Hash())
+	}
+	is.True(receipt != nil)
+	time.Sleep(50 * time.Millisecond)
+
+	var logs []*types.Log
+	is.NoErr(client.Call(&logs, "eth_getFilterChanges", logFilter))
+	is.Equal(len(logs), 1)
+	is.Equal(logs[0].TxHash, deploy.Hash())
+	is.NoErr(client.Call(&logs, "eth_getFilterChanges", logFilter))
+	is.Equal(len(logs), 0)
+	var hashes []common.Hash
+	is.NoErr(client.Call(&hashes, "eth_getFilterChanges", blockFilter))
+	is.True(len(hashes) > 0)
+
+	// topic positions are OR-sets, and null matches anything
+	crit := map[string]interface{}{
+		"fromBlock": "0x0",
+		"address":   []common.Address{receipt.ContractAddress, alice.Address},
+		"topics":    []interface{}{[]common.Hash{{1}, topic}},
+	}
+	is.NoErr(client.Call(&logs, "eth_getLogs", crit))
+	is.Equal(len(logs), 1)
+	is.NoErr(client.Call(&logs, "eth_getLogs", map[string]interface{}{"blockHash": receipt.BlockHash, "topics": []interface{}{nil}}))
+	is.Equal(len(logs), 1)
+	is.NoErr(client.Call(&logs, "eth_getLogs", map[string]interface{}{"fromBlock": "0x0", "topics": []interface{}{common.Hash{1}}}))
+	is.Equal(len(logs), 0)
+	is.NoErr(client.Call(&logs, "eth_getFilterLogs", logFilter))
+	is.Equal(len(logs), 1)
+
+	var removed bool
+	is.NoErr(client.Call(&removed, "eth_uninstallFilter", logFilter))
+	is.True(removed)
+	is.NoErr(client.Call(&removed, "eth_uninstallFilter", logFilter))
+	is.True(!removed)
+	is.True(client.Call(&logs, "eth_getFilterChanges", logFilter) != nil)
+}
+
+func TestLogStream(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runServer(t, "127.0.0.1:8028", "127.0.0.1:8029")
+	defer stop()
+	alice := eth.Accounts["alice"]
+	topic := common.BigToHash(big.NewInt(42))
+
+	client, err := rpc.Dial("ws://127.0.0.1:8029")
+	is.NoErr(err)
+	defer client.Close()
+	logs := make(chan types.Log)
+	filter := map[string]interface{}{
+		"address": alice.Address, // logs from other addresses are filtered out
+		"topics":  []interface{}{[]common.Hash{{1}, topic}},
+	}
+	_, err = client.EthSubscribe(context.Background(), logs, "logs", filter)
+	is.NoErr(err)
+
+	// subscribe without an address, matching any first topic
+	anyClient, err := rpc.Dial("ws://127.0.0.1:8029")
+	is.NoErr(err)
+	defer anyClient.Close()
+	anyLogs := make(chan types.Log)
+	anySub, err := anyClient.EthSubscribe(context.Background(), anyLogs, "logs", map[string]interface{}{"topics": []interface{}{nil}})
+	is.NoErr(err)
+
+	// malformed filters are rejected instead of subscribing
+	badClient, err := rpc.Dial("ws://127.0.0.1:8029")
+	is.NoErr(err)
+	defer badClient.Close()
+	_, err = badClient.EthSubscribe(context.Background(), make(chan types.Log), "logs", map[string]interface{}{"address": "0x1234"})
+	is.True(err != nil)
+	time.Sleep(100 * time.Millisecond)
+
+	// the constructor emits a log with the topic 0x2a
+	deploy, err := alice.Sign(types.NewContractCreation(alice.Nonce.Uint64(), big.NewInt(0), 100000, alice.TxOpts.GasPrice, common.FromHex("0x602a60006000a100")))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(deploy))
+	select {
+	case l := <-anyLogs:
+		is.Equal(l.TxHash, deploy.Hash())
+		is.Equal(l.Topics, []common.Hash{topic})
+	case err := <-anySub.Err():
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for log")
+	}
+	select {
+	case l := <-logs:
+		t.Fatal("log from the wrong address", l.Address.Hex())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestJSONRPC(t *testing.T) {
+	is := is.New(t)
+	_, stop := runServer(t, "127.0.0.1:8030", "127.0.0.1:8031")
+	defer stop()
+	post := func(body string) []byte {
+		resp, err := http.Post("http://127.0.0.1:8030", "application/json", strings.NewReader(body))
+		is.NoErr(err)
+		defer resp.Body.Close()
+		is.Equal(resp.StatusCode, http.StatusOK)
+		out, err := ioutil.ReadAll(resp.Body)
+		is.NoErr(err)
+		return out
+	}
+	type response struct {
+		Version string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Error   *jsonError      `json:"error"`
+	}
+
+	// ids are echoed verbatim, and notifications don't get a response
+	var batch []response
+	raw := post(`[
+		{"jsonrpc":"2.0","id":"a","method":"eth_getTransactionByHash","params":["0x0000000000000000000000000000000000000000000000000000000000000001"]},
+		{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["latest",false]},
+		{"jsonrpc":"2.0","id":3,"method":"eth_notAMethod"},
+		{"jsonrpc":"2.0","id":null,"method":"eth_getBalance","params":[]},
+		1
+	]`)
+	is.NoErr(json.Unmarshal(raw, &batch))
+	is.Equal(len(batch), 4)
+	is.Equal(string(batch[0].ID), `"a"`)
+	var fields []map[string]json.RawMessage
+	is.NoErr(json.Unmarshal(raw, &fields))
+	is.Equal(string(fields[0]["result"]), "null") // a null result is still included
+	is.Equal(string(batch[1].ID), "3")
+	is.Equal(batch[1].Error.Code, methodNotFoundCode)
+	is.Equal(string(batch[2].ID), "null")
+	is.Equal(batch[2].Error.Code, invalidParamsCode)
+	is.Equal(batch[3].Error.Code, invalidRequestCode)
+
+	var single response
+	is.NoErr(json.Unmarshal(post(`{"jsonrpc":"2.0","id":7,"method":"eth_getTransactionReceipt",`), &single))
+	is.Equal(single.Error.Code, parseErrorCode)
+	is.NoErr(json.Unmarshal(post(`[]`), &single))
+	is.Equal(single.Error.Code, invalidRequestCode)
+	is.NoErr(json.Unmarshal(post(`{"jsonrpc":"2.0","id":8,"method":"eth_getBlockByHash","params":["0x01",false]}`), &single))
+	is.Equal(single.Error.Code, invalidParamsCode)
+	is.Equal(len(post(`{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["latest",false]}`)), 0)
+}
+
+func TestUnlockedAccounts(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runServer(t, "127.0.0.1:8032", "127.0.0.1:8033")
+	defer stop()
+	alice, bob := eth.Accounts["alice"], eth.Accounts["bob"]
+	client, err := rpc.Dial("http://127.0.0.1:8032")
+	is.NoErr(err)
+
+	var unlocked []common.Address
+	is.NoErr(client.Call(&unlocked, "eth_accounts"))
+	is.Equal(len(unlocked), len(eth.Accounts))
+
+	// concurrent sends from the same account each get their own nonce
+	hashes := make([]common.Hash, 5)
+	var wg sync.WaitGroup
+	for i := range hashes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			args := map[string]interface{}{"from": alice.Address, "to": bob.Address, "value": "0x1"}
+			is.NoErr(client.Call(&hashes[i], "eth_sendTransaction", args))
+		}(i)
+	}
+	wg.Wait()
+	// contract creations have their gas estimated
+	var deploy common.Hash
+	is.NoErr(client.Call(&deploy, "eth_sendTransaction", map[string]interface{}{"from": alice.Address, "data": "0x602a60006000a100"}))
+	for _, hash := range append(hashes, deploy) {
+		var receipt *types.Receipt
+		for i := 0; i < 100 && receipt == nil; i++ {
+			time.Sleep(50 * time.Millisecond)
+			receipt, _ = eth.TxReceipt(hash)
+		}
+		is.True(receipt != nil)
+		is.Equal(receipt.Status, types.ReceiptStatusSuccessful)
+	}
+
+	// signed but unsent transactions can be sent later
+	var signed struct {
+		Raw hexutil.Bytes      `json:"raw"`
+		Tx  *types.Transaction `json:"tx"`
+	}
+	is.NoErr(client.Call(&signed, "eth_signTransaction", map[string]interface{}{"from": bob.Address, "to": alice.Address}))
+	is.Equal(signed.Tx.Gas(), uint64(21000))
+	var sent common.Hash
+	is.NoErr(client.Call(&sent, "eth_sendRawTransaction", signed.Raw))
+	is.Equal(sent, signed.Tx.Hash())
+
+	// both signing methods produce the same recoverable signature
+	var sig, personal hexutil.Bytes
+	is.NoErr(client.Call(&sig, "eth_sign", alice.Address, hexutil.Bytes("hello")))
+	is.NoErr(client.Call(&personal, "personal_sign", hexutil.Bytes("hello"), alice.Address))
+	is.Equal(sig, personal)
+	sig[64] -= 27
+	pub, err := crypto.SigToPub(accounts.TextHash([]byte("hello")), sig)
+	is.NoErr(err)
+	is.Equal(crypto.PubkeyToAddress(*pub), alice.Address)
+
+	is.True(client.Call(&sig, "eth_sign", common.Address{1}, hexutil.Bytes("hello")) != nil)
+}
+
+func TestSignTypedData(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runServer(t, "127.0.0.1:8034", "127.0.0.1:8035")
+	defer stop()
+	alice := eth.Accounts["alice"]
+	client, err := rpc.Dial("http://127.0.0.1:8034")
+	is.NoErr(err)
+
+	typed := `{
+		"types": {
+			"EIP712Domain": [{"name": "name", "type": "string"}, {"name": "chainId", "type": "uint256"}],
+			"Permit": [{"name": "spender", "type": "address"}, {"name": "value", "type": "uint256"}, {"name": "ids", "type": "uint8[]"}]
+		},
+		"primaryType": "Permit",
+		"domain": {"name": "ethlab", "chainId": "0x1"},
+		"message": {"spender": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB", "value": "1000000000000000000000", "ids": [1, 2]}
+	}`
+	var data eip712.TypedData
+	is.NoErr(json.Unmarshal([]byte(typed), &data))
+
+	// the typed data can be sent as an object or as a json encoded string
+	var sig, fromString hexutil.Bytes
+	is.NoErr(client.Call(&sig, "eth_signTypedData_v4", alice.Address, json.RawMessage(typed)))
+	is.NoErr(client.Call(&fromString, "eth_signTypedData_v4", alice.Address, typed))
+	is.Equal(sig, fromString)
+	valid, err := module.VerifyTypedData(&data, sig, alice.Address)
+	is.NoErr(err)
+	is.True(valid)
+
+	is.True(client.Call(&sig, "eth_signTypedData_v4", common.Address{1}, json.RawMessage(typed)) != nil)
+}
+
+func TestWSSession(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runServer(t, "127.0.0.1:8036", "127.0.0.1:8037")
+	defer stop()
+	alice, bob := eth.Accounts["alice"], eth.Accounts["bob"]
+
+	client, err := rpc.Dial("ws://127.0.0.1:8037")
+	is.NoErr(err)
+	defer client.Close()
+
+	// any method can be called over the websocket
+	var balance hexutil.Big
+	err = client.Call(&balance, "eth_getBalance", alice.Address, "latest")
+	is.NoErr(err)
+	is.Equal(balance.ToInt(), eth.LatestState().GetBalance(alice.Address))
+	batch := []rpc.BatchElem{
+		{Method: "eth_getTransactionCount", Args: []interface{}{alice.Address, "latest"}, Result: new(hexutil.Uint64)},
+		{Method: "eth_notAMethod", Result: new(interface{})},
+	}
+	is.NoErr(client.BatchCall(batch))
+	is.NoErr(batch[0].Error)
+	is.True(batch[1].Error != nil)
+
+	// several subscriptions share the connection, each with its own id
+	heads := make(chan *types.Header)
+	headSub, err := client.EthSubscribe(context.Background(), heads, "newHeads")
+	is.NoErr(err)
+	defer headSub.Unsubscribe()
+	hashes := make(chan common.Hash)
+	hashSub, err := client.EthSubscribe(context.Background(), hashes, "newPendingTransactions")
+	is.NoErr(err)
+	defer hashSub.Unsubscribe()
+
+	tx, err := alice.CreateSend(bob.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(tx))
+	select {
+	case hash := <-hashes:
+		is.Equal(hash, tx.Hash())
+	case err := <-hashSub.Err():
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for pending transaction")
+	}
+	eth.Commit()
+	select {
+	case head := <-heads:
+		is.True(head.Number.Uint64() <= eth.LatestBlock().NumberU64())
+	case err := <-headSub.Err():
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for new head")
+	}
+
+	// subscriptions can be cancelled individually
+	var ids [2]string
+	for i := range ids {
+		err = client.Call(&ids[i], "eth_subscribe", "newHeads")
+		is.NoErr(err)
+	}
+	is.True(ids[0] != ids[1])
+	var cancelled bool
+	is.NoErr(client.Call(&cancelled, "eth_unsubscribe", ids[0]))
+	is.True(cancelled)
+	is.NoErr(client.Call(&cancelled, "eth_unsubscribe", ids[0]))
+	is.True(!cancelled)
+	is.NoErr(client.Call(&cancelled, "eth_unsubscribe", ids[1]))
+	is.True(cancelled)
+}
+
+func TestSubscriptionOverflow(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sess := &session{ctx: ctx, send: make(chan []byte, 1)}
+
+	// notifications that don't fit in the queue fail instead of waiting
+	is.NoErr(sess.notify("0x1", 1))
+	is.Equal(sess.notify("0x1", 2), errSubscriptionOverflow)
+
+	// which ends the feed and its backend subscription
+	sink := make(chan core.NewTxsEvent, 1)
+	sub := event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		return nil
+	})
+	sink <- core.NewTxsEvent{Txs: []*types.Transaction{types.NewTransaction(0, common.Address{}, nil, 0, nil, nil)}}
+	done := make(chan struct{})
+	go func() {
+		feedFullTxs(ctx, func(result interface{}) error { return sess.notify("0x1", result) }, sub, sink)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("feed kept waiting on a full session")
+	}
+	_, open := <-sub.Err()
+	is.True(!open)
+}
+
+func TestSharedPort(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runServer(t, "127.0.0.1:8038", "")
+	defer stop()
+	alice := eth.Accounts["alice"]
+
+	httpClient, err := rpc.Dial("http://127.0.0.1:8038")
+	is.NoErr(err)
+	defer httpClient.Close()
+	wsClient, err := rpc.Dial("ws://127.0.0.1:8038")
+	is.NoErr(err)
+	defer wsClient.Close()
+
+	// both transports are served by the same address
+	var httpNonce, wsNonce hexutil.Uint64
+	is.NoErr(httpClient.Call(&httpNonce, "eth_getTransactionCount", alice.Address, "latest"))
+	is.NoErr(wsClient.Call(&wsNonce, "eth_getTransactionCount", alice.Address, "latest"))
+	is.Equal(httpNonce, wsNonce)
+
+	heads := make(chan *types.Header)
+	sub, err := wsClient.EthSubscribe(context.Background(), heads, "newHeads")
+	is.NoErr(err)
+	defer sub.Unsubscribe()
+	eth.Commit()
+	select {
+	case head := <-heads:
+		is.True(head.Number.Uint64() <= eth.LatestBlock().NumberU64())
+	case err := <-sub.Err():
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for new head")
+	}
+}
+
+func TestIPC(t *testing.T) {
+	is := is.New(t)
+	dir, err := ioutil.TempDir("", "ethlab")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ethlab.ipc")
+
+	config := thereum.DefaultConfig()
+	config.Allocation["alice"] = "1000000000000000000000"
+	eth, err := thereum.New(config, nil)
+	is.NoErr(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go eth.Run(ctx, wg)
+	srvr := NewServer(ctx, "127.0.0.1:8039", eth)
+	served := make(chan error, 1)
+	go func() {
+		served <- srvr.ServeIPC(path)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := rpc.DialIPC(context.Background(), path)
+	is.NoErr(err)
+	defer client.Close()
+	alice := eth.Accounts["alice"]
+	var balance hexutil.Big
+	is.NoErr(client.Call(&balance, "eth_getBalance", alice.Address, "latest"))
+	is.Equal(balance.ToInt(), eth.LatestState().GetBalance(alice.Address))
+
+	heads := make(chan *types.Header)
+	sub, err := client.EthSubscribe(context.Background(), heads, "newHeads")
+	is.NoErr(err)
+	eth.Commit()
+	select {
+	case head := <-heads:
+		is.True(head.Number.Uint64() <= eth.LatestBlock().NumberU64())
+	case err := <-sub.Err():
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for new head")
+	}
+
+	// the socket is removed once the context is cancelled
+	cancel()
+	select {
+	case err := <-served:
+		is.NoErr(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the ipc server to stop")
+	}
+	_, err = os.Stat(path)
+	is.True(os.IsNotExist(err))
+	wg.Wait()
+}
+
+func TestRecordReplay(t *testing.T) {
+	is := is.New(t)
+	config := thereum.DefaultConfig()
+	config.Seed = "replay"
+	config.Allocation["alice"] = "1000000000000000000000"
+	config.Allocation["bob"] = "1000000000000000000000"
+
+	// record a session with a running chain
+	eth, err := thereum.New(config, nil)
+	is.NoErr(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go eth.Run(ctx, wg)
+	srvr := NewServer(ctx, "127.0.0.1:8040", eth)
+	recording := new(bytes.Buffer)
+	recorder := NewRecorder(recording)
+	is.NoErr(recorder.WriteHeader(RecordingHeader{Seed: config.Seed}))
+	srvr.Record(recorder)
+	go srvr.ListenAndServe()
+	time.Sleep(100 * time.Millisecond)
+
+	alice, bob := eth.Accounts["alice"], eth.Accounts["bob"]
+	client, err := rpc.Dial("ws://127.0.0.1:8040")
+	is.NoErr(err)
+	var balance hexutil.Big
+	is.NoErr(client.Call(&balance, "eth_getBalance", alice.Address, "latest"))
+	var hash common.Hash
+	is.NoErr(client.Call(&hash, "eth_sendTransaction", map[string]interface{}{
+		"from":  alice.Address,
+		"to":    bob.Address,
+		"value": "0x1",
+	}))
+	// eth sent by the faucet is recorded too
+	dripped := common.BigToAddress(big.NewInt(0xd12))
+	drip, err := module.RequestETH("127.0.0.1:8040", dripped.Hex(), big.NewInt(1))
+	is.NoErr(err)
+	var filter string
+	is.NoErr(client.Call(&filter, "eth_newFilter", map[string]interface{}{"fromBlock": "0x0"}))
+	heads := make(chan *types.Header)
+	sub, err := client.EthSubscribe(context.Background(), heads, "newHeads")
+	is.NoErr(err)
+	<-heads
+	sub.Unsubscribe()
+	time.Sleep(200 * time.Millisecond)
+	var receipt map[string]interface{}
+	is.NoErr(client.Call(&receipt, "eth_getTransactionReceipt", hash))
+	is.True(receipt != nil)
+	batch := []rpc.BatchElem{
+		{Method: "eth_getBalance", Args: []interface{}{bob.Address, "latest"}, Result: new(hexutil.Big)},
+		{Method: "eth_getFilterLogs", Args: []interface{}{filter}, Result: new([]types.Log)},
+		{Method: "eth_uninstallFilter", Args: []interface{}{filter}, Result: new(bool)},
+	}
+	is.NoErr(client.BatchCall(batch))
+	is.NoErr(client.Call(&balance, "eth_getBalance", dripped, "latest"))
+	is.Equal(balance.ToInt(), big.NewInt(1))
+	client.Close()
+	cancel()
+	wg.Wait()
+	is.True(bytes.Contains(recording.Bytes(), []byte(drip.TxHash.Hex()[2:])))
+
+	// replay it on a fresh chain booted with the recorded seed
+	header, err := ReadRecordingHeader(bytes.NewReader(recording.Bytes()))
+	is.NoErr(err)
+	is.True(header != nil)
+	is.Equal(header.Seed, "replay")
+	fresh, err := thereum.New(config, nil)
+	is.NoErr(err)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	replayer := NewServer(ctx, "", fresh)
+	mismatches, err := replayer.Replay(bytes.NewReader(recording.Bytes()))
+	is.NoErr(err)
+	for _, m := range mismatches {
+		t.Errorf("line %d %s\nrecorded: %s\nreplayed: %s", m.Line, m.Method, m.Recorded, m.Replayed)
+	}
+
+	// a different chain doesn't match the recording
+	config.Seed = "other"
+	other, err := thereum.New(config, nil)
+	is.NoErr(err)
+	mismatches, err = NewServer(ctx, "", other).Replay(bytes.NewReader(recording.Bytes()))
+	is.NoErr(err)
+	is.True(len(mismatches) > 0)
+}
+
+// signJWT makes a HS256 json web token with the claims
+func signJWT(secret []byte, claims string) string {
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuth(t *testing.T) {
+	is := is.New(t)
+	config := thereum.DefaultConfig()
+	eth, err := thereum.New(config, nil)
+	is.NoErr(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srvr := NewServer(ctx, "127.0.0.1:8041", eth)
+	secret := crypto.Keccak256([]byte("secret"))
+	is.True(srvr.SetAuth(thereum.AuthConfig{Namespaces: map[string]string{"txpool": "admin"}}) != nil) // admins need a secret
+	is.NoErr(srvr.SetAuth(thereum.AuthConfig{
+		Origins:    []string{"http://allowed.test"},
+		JWTSecret:  hexutil.Encode(secret),
+		Namespaces: map[string]string{"*": "public", "txpool": "admin", "faucet": "admin"},
+	}))
+	go srvr.ListenAndServe()
+	time.Sleep(100 * time.Millisecond)
+
+	token := signJWT(secret, fmt.Sprintf(`{"iat":%d}`, time.Now().Unix()))
+	post := func(path, body, origin, token string) (*http.Response, *rpcMessage) {
+		req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1:8041"+path, strings.NewReader(body))
+		is.NoErr(err)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		is.NoErr(err)
+		defer resp.Body.Close()
+		var msg rpcMessage
+		json.NewDecoder(resp.Body).Decode(&msg)
+		return resp, &msg
+	}
+	const status = `{"jsonrpc":"2.0","id":1,"method":"txpool_status"}`
+
+	// public namespaces don't need a token, but admin ones do
+	_, msg := post("/", `{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0x0000000000000000000000000000000000000001","latest"]}`, "", "")
+	is.True(msg.Error == nil)
+	_, msg = post("/", status, "", "")
+	is.Equal(msg.Error.Code, unauthorizedCode)
+	_, msg = post("/", status, "", token)
+	is.True(msg.Error == nil)
+	resp, _ := post("/requestETH", `{"address":"0x0000000000000000000000000000000000000001","amount":1}`, "", "")
+	is.Equal(resp.StatusCode, http.StatusUnauthorized)
+
+	// invalid tokens are rejected
+	resp, _ = post("/", status, "", signJWT([]byte("wrong"), `{}`))
+	is.Equal(resp.StatusCode, http.StatusUnauthorized)
+	resp, _ = post("/", status, "", signJWT(secret, fmt.Sprintf(`{"exp":%d}`, time.Now().Add(-time.Minute).Unix())))
+	is.Equal(resp.StatusCode, http.StatusUnauthorized)
+
+	// only allowed origins can make browser requests
+	resp, _ = post("/", status, "http://evil.test", token)
+	is.Equal(resp.StatusCode, http.StatusForbidden)
+	resp, msg = post("/", status, "http://allowed.test", token)
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(resp.Header.Get("Access-Control-Allow-Origin"), "http://allowed.test")
+	is.True(msg.Error == nil)
+	preflight, err := http.NewRequest(http.MethodOptions, "http://127.0.0.1:8041", nil)
+	is.NoErr(err)
+	preflight.Header.Set("Origin", "http://allowed.test")
+	preflight.Header.Set("Access-Control-Request-Headers", "authorization")
+	resp, err = http.DefaultClient.Do(preflight)
+	is.NoErr(err)
+	resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusNoContent)
+	is.True(strings.Contains(resp.Header.Get("Access-Control-Allow-Headers"), "Authorization"))
+
+	// websockets follow the same rules, using the token query parameter
+	_, err = rpc.DialWebsocket(context.Background(), "ws://127.0.0.1:8041", "http://evil.test")
+	is.True(err != nil)
+	public, err := rpc.DialWebsocket(context.Background(), "ws://127.0.0.1:8041", "http://allowed.test")
+	is.NoErr(err)
+	defer public.Close()
+	var result interface{}
+	is.True(public.Call(&result, "txpool_status") != nil)
+	admin, err := rpc.DialWebsocket(context.Background(), "ws://127.0.0.1:8041?token="+token, "http://allowed.test")
+	is.NoErr(err)
+	defer admin.Close()
+	is.NoErr(admin.Call(&result, "txpool_status"))
+}
+
+func TestFaucetPolicy(t *testing.T) {
+	is := is.New(t)
+	alice := common.HexToAddress("0x0000000000000000000000000000000000000a11")
+	bob := common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	carol := common.HexToAddress("0x0000000000000000000000000000000000000ca1")
+	dave := common.HexToAddress("0x0000000000000000000000000000000000000da5")
+	p, err := newFaucetPolicy(thereum.FaucetConfig{
+		MaxDrip:         "100",
+		AddressCooldown: 60,
+		IPCooldown:      10,
+		AddressDailyCap: "150",
+		IPDailyCap:      "250",
+		Deny:            []string{carol.Hex()},
+		Budget:          "300",
+	})
+	is.NoErr(err)
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+	reason := func(err error) string {
+		refused, ok := err.(*faucetError)
+		is.True(ok)
+		return refused.reason
+	}
+
+	_, err = p.reserve(carol, "1.1.1.1", big.NewInt(1))
+	is.Equal(reason(err), "denied")
+	_, err = p.reserve(alice, "1.1.1.1", big.NewInt(101))
+	is.Equal(reason(err), "max_drip")
+
+	_, err = p.reserve(alice, "1.1.1.1", big.NewInt(100))
+	is.NoErr(err)
+	_, err = p.reserve(alice, "2.2.2.2", big.NewInt(10))
+	is.Equal(reason(err), "address_cooldown")
+	is.Equal(err.(*faucetError).retryAfter, time.Minute)
+	_, err = p.reserve(bob, "1.1.1.1", big.NewInt(10))
+	is.Equal(reason(err), "ip_cooldown")
+
+	// failed sends don't count against any limit
+	now = now.Add(time.Minute)
+	undo, err := p.reserve(bob, "1.1.1.1", big.NewInt(100))
+	is.NoErr(err)
+	undo()
+	_, err = p.reserve(bob, "1.1.1.1", big.NewInt(100))
+	is.NoErr(err)
+
+	now = now.Add(time.Minute)
+	_, err = p.reserve(alice, "2.2.2.2", big.NewInt(60))
+	is.Equal(reason(err), "address_daily_cap")
+	_, err = p.reserve(dave, "1.1.1.1", big.NewInt(60))
+	is.Equal(reason(err), "ip_daily_cap")
+	_, err = p.reserve(bob, "2.2.2.2", big.NewInt(50))
+	is.NoErr(err)
+
+	// caps reset each day, but the budget doesn't
+	now = now.Add(24 * time.Hour)
+	_, err = p.reserve(alice, "1.1.1.1", big.NewInt(60))
+	is.Equal(reason(err), "budget")
+	_, err = p.reserve(alice, "1.1.1.1", big.NewInt(50))
+	is.NoErr(err)
+
+	// only allowed addresses can use the faucet if there's an allowlist
+	p, err = newFaucetPolicy(thereum.FaucetConfig{Allow: []string{alice.Hex()}})
+	is.NoErr(err)
+	_, err = p.reserve(bob, "1.1.1.1", big.NewInt(1))
+	is.Equal(reason(err), "not_allowed")
+	_, err = p.reserve(alice, "1.1.1.1", big.NewInt(1))
+	is.NoErr(err)
+
+	_, err = newFaucetPolicy(thereum.FaucetConfig{MaxDrip: "1.5"})
+	is.True(err != nil)
+	_, err = newFaucetPolicy(thereum.FaucetConfig{Deny: []string{"bob"}})
+	is.True(err != nil)
+}
+
+func TestFaucetResponses(t *testing.T) {
+	is := is.New(t)
+	eth, err := thereum.New(thereum.DefaultConfig(), nil)
+	is.NoErr(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srvr := NewServer(ctx, "", eth)
+	is.NoErr(srvr.SetFaucet(thereum.FaucetConfig{MaxDrip: "100", AddressCooldown: 60}))
+	request := func(body string) (*httptest.ResponseRecorder, faucetResp) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/requestETH", strings.NewReader(body))
+		srvr.router.ServeHTTP(w, r)
+		var resp faucetResp
+		is.NoErr(json.Unmarshal(w.Body.Bytes(), &resp))
+		return w, resp
+	}
+
+	w, resp := request(`{"address":"bob","amount":1}`)
+	is.Equal(w.Code, http.StatusBadRequest)
+	is.Equal(resp.Reason, "bad_request")
+	w, resp = request(`{"address":"0x0000000000000000000000000000000000000001","amount":101}`)
+	is.Equal(w.Code, http.StatusBadRequest)
+	is.Equal(resp.Reason, "max_drip")
+
+	srvr.faucet.address.take(common.HexToAddress("0x01").Hex(), big.NewInt(1), time.Now())
+	w, resp = request(`{"address":"0x0000000000000000000000000000000000000001","amount":1}`)
+	is.Equal(w.Code, http.StatusTooManyRequests)
+	is.Equal(resp.Reason, "address_cooldown")
+	is.Equal(w.Header().Get("Retry-After"), "60")
+	is.Equal(resp.RetryAfter, int64(60))
+	is.Equal(w.Header().Get("content-type"), "application/json")
+}
+
+func TestFaucetDrip(t *testing.T) {
+	is := is.New(t)
+	_, stop := runServer(t, "127.0.0.1:8042", "")
+	defer stop()
+
+	// concurrent requests each get their own nonce, and wait for their own receipt
+	const requests = 5
+	amount := big.NewInt(1000000000000000000)
+	receipts := make([]*module.FaucetReceipt, requests)
+	errs := make([]error, requests)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			addr := common.BigToAddress(big.NewInt(int64(0xfa0 + i)))
+			receipts[i], errs[i] = module.RequestETH("127.0.0.1:8042", addr.Hex(), amount)
+		}(i)
+	}
+	wg.Wait()
+	hashes := make(map[common.Hash]bool)
+	for i, rcpt := range receipts {
+		is.NoErr(errs[i])
+		is.True(rcpt.BlockNumber > 0)
+		is.Equal(rcpt.Balance, amount)
+		hashes[rcpt.TxHash] = true
+	}
+	is.Equal(len(hashes), requests)
+
+	// refusals are returned as structured errors
+	_, err := module.RequestETH("127.0.0.1:8042", "bob", amount)
+	faucetErr, ok := err.(*module.FaucetError)
+	is.True(ok)
+	is.Equal(faucetErr.Status, http.StatusBadRequest)
+	is.Equal(faucetErr.Reason, "bad_request")
+}
+
+func TestFaucetTimeout(t *testing.T) {
+	is := is.New(t)
+	// the chain isn't running, so nothing gets mined
+	eth, err := thereum.New(thereum.DefaultConfig(), nil)
+	is.NoErr(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srvr := NewServer(ctx, "", eth)
+	is.NoErr(srvr.SetFaucet(thereum.FaucetConfig{AddressCooldown: 60, ReceiptTimeout: 1}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/requestETH", strings.NewReader(`{"address":"0x0000000000000000000000000000000000000001","amount":1}`))
+	srvr.router.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusGatewayTimeout)
+	var resp faucetResp
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &resp))
+	is.Equal(resp.Reason, "receipt_timeout")
+	is.True(resp.TxHash != nil)
+	tx, _, _, _, err := eth.TransactionByHash(*resp.TxHash)
+	is.NoErr(err)
+	is.Equal(tx.Hash(), *resp.TxHash)
+
+	// the eth might still be sent, so it keeps counting against the limits
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/requestETH", strings.NewReader(`{"address":"0x0000000000000000000000000000000000000001","amount":1}`))
+	srvr.router.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusTooManyRequests)
+}
+
+func TestFaucetHeld(t *testing.T) {
+	is := is.New(t)
+	config := thereum.DefaultConfig()
+	eth, err := thereum.New(config, nil)
+	is.NoErr(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go eth.Run(ctx, wg)
+	defer wg.Wait()
+	defer cancel()
+	srvr := NewServer(ctx, "127.0.0.1:8043", eth)
+
+	// receipt timeouts must leave time to write the response
+	err = srvr.SetFaucet(thereum.FaucetConfig{ReceiptTimeout: 20})
+	is.True(err != nil)
+	is.NoErr(srvr.SetFaucet(thereum.FaucetConfig{ReceiptTimeout: 1}))
+	go srvr.ListenAndServe()
+	time.Sleep(100 * time.Millisecond)
+
+	// nothing is mined while the chain is held, so the request times out, but the
+	// response still makes it back over the connection
+	addr := common.BigToAddress(big.NewInt(0xfe1d))
+	var faucetErr *module.FaucetError
+	eth.Hold(func() {
+		_, err := module.RequestETH("127.0.0.1:8043", addr.Hex(), big.NewInt(1))
+		var ok bool
+		faucetErr, ok = err.(*module.FaucetError)
+		is.True(ok)
+	})
+	is.Equal(faucetErr.Status, http.StatusGatewayTimeout)
+	is.Equal(faucetErr.Reason, "receipt_timeout")
+	is.True(faucetErr.TxHash != nil)
+
+	// once the chain moves again, the eth is sent
+	var rcpt *types.Receipt
+	for i := 0; i < 100 && rcpt == nil; i++ {
+		time.Sleep(50 * time.Millisecond)
+		rcpt, _ = eth.TxReceipt(*faucetErr.TxHash)
+	}
+	is.True(rcpt != nil)
+}
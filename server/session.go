@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// sendQueue is the number of messages that can wait to be written to a connection
+const sendQueue = 256
+
+////////////////////////////////
+//	Sessions
+//////////////////////////////
+
+// session is the state of a persistent json rpc connection, such as a websocket or
+// ipc connection. Requests are read and answered in order, while subscriptions
+// stream notifications concurrently. Every message is queued to be written by the
+// connection's write loop, so writes never overlap.
+type session struct {
+	srv    *Server
+	role   permission // role is the caller's access, decided when connecting
+	ctx    context.Context
+	cancel context.CancelFunc
+	send   chan []byte
+	subs   map[rpc.ID]context.CancelFunc
+	feeds  []func() // feeds wait to be started until their subscription id is sent
+	mu     sync.Mutex
+}
+
+func newSession(s *Server, role permission) *session {
+	ctx, cancel := context.WithCancel(s.ctx)
+	return &session{
+		srv:    s,
+		role:   role,
+		ctx:    ctx,
+		cancel: cancel,
+		send:   make(chan []byte, sendQueue),
+		subs:   make(map[rpc.ID]context.CancelFunc),
+	}
+}
+
+// handle answers a single request or batch read from the connection. Only the read
+// loop calls handle.
+func (sess *session) handle(body []byte) error {
+	out := sess.srv.handle(body, sess.call)
+	if out != nil {
+		err := sess.write(out)
+		if err != nil {
+			return err
+		}
+	}
+	// subscriptions only start streaming after their id has been sent
+	sess.startFeeds()
+	return nil
+}
+
+// startFeeds starts streaming each new subscription. Read loops must also call it
+// once they return, so that feeds left waiting unsubscribe from the backend.
+func (sess *session) startFeeds() {
+	for _, feed := range sess.feeds {
+		go feed()
+	}
+	sess.feeds = nil
+}
+
+// errSessionClosed is returned when writing to a session that has ended
+var errSessionClosed = errors.New("session closed")
+
+// write queues a message to be written by the write loop
+func (sess *session) write(msg []byte) error {
+	select {
+	case sess.send <- msg:
+		return nil
+	case <-sess.ctx.Done():
+		return errSessionClosed
+	}
+}
+
+// errSubscriptionOverflow is returned when a notification doesn't fit in the session's
+// send queue
+var errSubscriptionOverflow = errors.New("subscription overflow: client is not reading notifications")
+
+// tryWrite queues a message without waiting for the write loop
+func (sess *session) tryWrite(msg []byte) error {
+	select {
+	case sess.send <- msg:
+		return nil
+	case <-sess.ctx.Done():
+		return errSessionClosed
+	default:
+		return errSubscriptionOverflow
+	}
+}
+
+// notify queues a subscription notification. Notifications never wait on the
+// connection, so a client that falls a full queue behind loses the subscription
+// instead of stalling the backend feeding it.
+func (sess *session) notify(id rpc.ID, result interface{}) error {
+	params, err := json.Marshal(subscriptionResult{
+		Subscription: string(id),
+		Result:       result,
+	})
+	if err != nil {
+		return err
+	}
+	msg, err := json.Marshal(rpcMessage{
+		Version: "2.0",
+		Method:  "eth_subscription",
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+	return sess.tryWrite(msg)
+}
+
+// call handles the subscription methods, and passes everything else to the server's
+// procedures
+func (sess *session) call(req *rpcMessage) *rpcMessage {
+	if !sess.srv.policy.allowed(req.Method, sess.role) {
+		return errorMessage(req.ID, unauthorizedCode, fmt.Sprintf("the method %s requires authorization", req.Method))
+	}
+	switch req.Method {
+	case "eth_subscribe":
+		return sess.subscribe(req)
+	case "eth_unsubscribe":
+		return sess.unsubscribe(req)
+	}
+	return sess.srv.call(req)
+}
+
+// subscribe starts a new subscription with a unique id
+func (sess *session) subscribe(req *rpcMessage) *rpcMessage {
+	// "params":["logs", {"address": "0x8320fe7702b96808f7bbc0d4a888ed1468216cfd"}]
+	var kind string
+	err := unmarshalParams(req, 1, &kind)
+	if err != nil {
+		return errorMessage(req.ID, invalidParamsCode, err.Error())
+	}
+	sub, has := subscriptions[kind]
+	if !has {
+		return errorMessage(req.ID, invalidParamsCode, fmt.Sprintf("unsupported subscription: %s", kind))
+	}
+	id := rpc.NewID()
+	ctx, cancel := context.WithCancel(sess.ctx)
+	feed, err := sub(ctx, sess.srv.back, req, func(result interface{}) error {
+		return sess.notify(id, result)
+	})
+	if err != nil {
+		cancel()
+		return errorMessage(req.ID, errorCode(err), err.Error())
+	}
+	sess.mu.Lock()
+	sess.subs[id] = cancel
+	sess.mu.Unlock()
+	sess.feeds = append(sess.feeds, func() {
+		feed()
+		// feeds can also end on their own, such as when a write fails
+		sess.mu.Lock()
+		delete(sess.subs, id)
+		sess.mu.Unlock()
+		cancel()
+	})
+	return &rpcMessage{Version: "2.0", ID: req.ID, Result: id}
+}
+
+// unsubscribe cancels a subscription, reporting if it existed
+func (sess *session) unsubscribe(req *rpcMessage) *rpcMessage {
+	// "params":["0x9cef478923ff08bf67fde6c64013158d"]
+	var id rpc.ID
+	err := unmarshalParams(req, 1, &id)
+	if err != nil {
+		return errorMessage(req.ID, invalidParamsCode, err.Error())
+	}
+	sess.mu.Lock()
+	cancel, has := sess.subs[id]
+	delete(sess.subs, id)
+	sess.mu.Unlock()
+	if has {
+		cancel()
+	}
+	return &rpcMessage{Version: "2.0", ID: req.ID, Result: has}
+}
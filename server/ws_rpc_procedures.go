@@ -2,35 +2,54 @@ package server
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/eth/filters"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/evan-forbes/ethlab/thereum"
-	"github.com/gorilla/websocket"
 )
 
+// notifier sends a single notification for a subscription
+type notifier func(result interface{}) error
+
+// subProcedure validates a subscription request and subscribes to the backend. The
+// returned feed streams notifications until ctx is done or a notification fails.
+type subProcedure func(ctx context.Context, eth *thereum.Thereum, req *rpcMessage, notify notifier) (feed func(), err error)
+
+// subscriptions maps each kind of eth_subscribe subscription to its procedure
+var subscriptions = map[string]subProcedure{
+	"newHeads":               subHeads,
+	"newPendingTransactions": subPendingTxs,
+	"logs":                   subLogs,
+}
+
+// sinkSize is the number of backend events a subscription buffers while its
+// notifications are being written
+const sinkSize = 64
+
+// subscriptionResult is the params of an eth_subscription notification
+type subscriptionResult struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
 ////////////////////////////////
 //	Streaming Heads
 //////////////////////////////
 
-func subHeads(ctx context.Context, eth *thereum.Thereum, conn *websocket.Conn) {
-	sink := make(chan *types.Header)
+func subHeads(ctx context.Context, eth *thereum.Thereum, req *rpcMessage, notify notifier) (func(), error) {
+	sink := make(chan *types.Header, sinkSize)
 	sub := eth.Events.SubscribeNewHeads(sink)
-	conn.WriteJSON(rpcMessage{
-		Version: "2.0",
-		ID:      1,
-		Result:  sub.ID,
-	})
-	feedHeads(ctx, conn, sub, sink)
+	return func() { feedHeads(ctx, notify, sub, sink) }, nil
 }
 
-func feedHeads(ctx context.Context, conn *websocket.Conn, sub *filters.Subscription, heads <-chan *types.Header) {
-	defer conn.Close()
+func feedHeads(ctx context.Context, notify notifier, sub *filters.Subscription, heads <-chan *types.Header) {
 	defer sub.Unsubscribe()
 	for {
 		select {
@@ -39,36 +58,78 @@ func feedHeads(ctx context.Context, conn *websocket.Conn, sub *filters.Subscript
 		case <-ctx.Done():
 			return
 		case head := <-heads:
-			result := headPacket{
-				Subscription: string(sub.ID),
-				Result:       head,
-			}
-			// marshal the log in the expected format
-			resultBytes, err := json.Marshal(result)
+			err := notify(head)
 			if err != nil {
-				log.Println("failed to marshal head during streaming")
+				log.Println("failed to write head during streaming", err)
 				return
 			}
-			// create the rpc msg using the marshaled log
-			msg := rpcMessage{
-				Version: "2.0",
-				Method:  "eth_subscription",
-				Params:  resultBytes,
-			}
-			// write over the websocket
-			err = conn.WriteJSON(msg)
-			if err != nil {
-				fmt.Println("ws writing error:", err)
-				log.Println("failed to marshal head during streaming")
-				return
+		}
+	}
+}
+
+////////////////////////////////
+//	Streaming Pending Transactions
+//////////////////////////////
+
+// subPendingTxs is the procedure to stream transactions as they're added to the
+// txpool. Hashes are streamed by default, while passing true as the second parameter
+// streams full transactions.
+func subPendingTxs(ctx context.Context, eth *thereum.Thereum, req *rpcMessage, notify notifier) (func(), error) {
+	// "params":["newPendingTransactions", true]
+	var kind string
+	var full bool
+	err := unmarshalParams(req, 1, &kind, &full)
+	if err != nil {
+		return nil, err
+	}
+	if full {
+		sink := make(chan core.NewTxsEvent, sinkSize)
+		sub := eth.SubscribeNewTxsEvent(sink)
+		return func() { feedFullTxs(ctx, notify, sub, sink) }, nil
+	}
+	sink := make(chan []common.Hash, sinkSize)
+	sub := eth.Events.SubscribePendingTxs(sink)
+	return func() { feedTxHashes(ctx, notify, sub, sink) }, nil
+}
+
+func feedTxHashes(ctx context.Context, notify notifier, sub *filters.Subscription, hashes <-chan []common.Hash) {
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-sub.Err():
+			return
+		case <-ctx.Done():
+			return
+		case hs := <-hashes:
+			for _, h := range hs {
+				err := notify(h)
+				if err != nil {
+					log.Println("failed to write pending transaction during streaming", err)
+					return
+				}
 			}
 		}
 	}
 }
 
-type headPacket struct {
-	Subscription string        `json:"subscription"`
-	Result       *types.Header `json:"result"`
+func feedFullTxs(ctx context.Context, notify notifier, sub event.Subscription, events <-chan core.NewTxsEvent) {
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-sub.Err():
+			return
+		case <-ctx.Done():
+			return
+		case ev := <-events:
+			for _, tx := range ev.Txs {
+				err := notify(newRPCTransaction(tx, common.Hash{}, 0, 0))
+				if err != nil {
+					log.Println("failed to write pending transaction during streaming", err)
+					return
+				}
+			}
+		}
+	}
 }
 
 ////////////////////////////////
@@ -76,38 +137,22 @@ type headPacket struct {
 //////////////////////////////
 
 // subLogs is the procedure to stream logs via websocket
-func subLogs(ctx context.Context, eth *thereum.Thereum, conn *websocket.Conn, rawPrms json.RawMessage) {
-	// attempt to unmarshal in
-	params := []interface{}{}
-	var method string
-	var query query
-	params = append(params, method, query)
-	err := json.Unmarshal(rawPrms, &params)
+func subLogs(ctx context.Context, eth *thereum.Thereum, req *rpcMessage, notify notifier) (func(), error) {
+	// "params":["logs", {"address":["0x8320fe7702b96808f7bbc0d4a888ed1468216cfd"],"topics":[null,["0xd78a0cb8bb633d06981248b816e7bd33c2a35a6089241d099fa519e361cab902"]]}]
+	query, err := logQuery(req)
 	if err != nil {
-		log.Println("failure to unmarshal request", err)
-		return
+		return nil, invalidParams(fmt.Errorf("invalid logs filter: %s", err))
 	}
 	// subscribe via the backend's EventSystem
-	sink := make(chan []*types.Log)
-	sub, err := eth.Events.SubscribeLogs(query.FilterQuery(), sink)
-	if err != nil {
-		fmt.Println("subscription error")
-	}
-	err = conn.WriteJSON(rpcMessage{
-		Version: "2.0",
-		ID:      1,
-		Result:  sub.ID,
-	})
+	sink := make(chan []*types.Log, sinkSize)
+	sub, err := eth.Events.SubscribeLogs(query, sink)
 	if err != nil {
-		// TODO: handle errors in a more meaningful and way
-		fmt.Println("could not write json to ws", err)
+		return nil, invalidParams(fmt.Errorf("invalid logs filter: %s", err))
 	}
-	// Write the logs to the connection
-	feedLogs(ctx, conn, sub, sink)
+	return func() { feedLogs(ctx, notify, sub, sink) }, nil
 }
 
-func feedLogs(ctx context.Context, conn *websocket.Conn, sub *filters.Subscription, logs <-chan []*types.Log) {
-	defer conn.Close()
+func feedLogs(ctx context.Context, notify notifier, sub *filters.Subscription, logs <-chan []*types.Log) {
 	defer sub.Unsubscribe()
 	for {
 		select {
@@ -117,25 +162,9 @@ func feedLogs(ctx context.Context, conn *websocket.Conn, sub *filters.Subscripti
 			return
 		case ls := <-logs:
 			for _, l := range ls {
-				fmt.Println("------", "found a log", l)
-				result := logPacket{
-					Subscription: string(sub.ID),
-					Result:       l,
-				}
-				resultBytes, err := json.Marshal(result)
-				if err != nil {
-					log.Println("failed to marshal log during streaming")
-					return
-				}
-				msg := *&rpcMessage{
-					Version: "2.0",
-					ID:      1,
-					Method:  "eth_subscribe",
-					Params:  resultBytes,
-				}
-				err = conn.WriteJSON(msg)
+				err := notify(l)
 				if err != nil {
-					log.Println("failed to marshal log during streaming")
+					log.Println("failed to write log during streaming", err)
 					return
 				}
 			}
@@ -143,29 +172,24 @@ func feedLogs(ctx context.Context, conn *websocket.Conn, sub *filters.Subscripti
 	}
 }
 
-type logPacket struct {
-	Subscription string     `json:"subscription"`
-	Result       *types.Log `json:"result"`
-}
+// maxTopics is the number of topic positions a log can have
+const maxTopics = 4
 
-// query helps unmarshall a json filter query
-// TODO: ensure that any format of query sent via rpc works
-type query struct {
-	Address string   `json:"address"`
-	Topics  []string `json:"topics"`
-	From    string   `json:"from_block"`
-	To      string   `json:"to_block"`
-	Block   string   `json:"blockHash"`
-}
-
-// FilterQuery converts query into a standardized ethereum.FilterQuery
-func (q *query) FilterQuery() ethereum.FilterQuery {
-	var topics []common.Hash
-	for _, t := range q.Topics {
-		topics = append(topics, common.HexToHash(t))
+// logQuery parses the optional filter object of a logs subscription. The address can
+// be a single address or an array, and each topic position can be null to match
+// anything, a single topic, or an array of topics to match any of them.
+func logQuery(req *rpcMessage) (ethereum.FilterQuery, error) {
+	var kind string
+	var crit filters.FilterCriteria
+	err := unmarshalParams(req, 1, &kind, &crit)
+	if err != nil {
+		return ethereum.FilterQuery{}, err
+	}
+	if crit.BlockHash != nil {
+		return ethereum.FilterQuery{}, errors.New("blockHash is not supported by subscriptions")
 	}
-	return ethereum.FilterQuery{
-		Addresses: []common.Address{common.HexToAddress(q.Address)},
-		Topics:    [][]common.Hash{topics},
+	if len(crit.Topics) > maxTopics {
+		return ethereum.FilterQuery{}, fmt.Errorf("too many topic positions, logs have at most %d", maxTopics)
 	}
+	return ethereum.FilterQuery(crit), nil
 }
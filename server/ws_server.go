@@ -1,37 +1,52 @@
 package server
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// wsWriteWait is the time allowed to write a frame to the client
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is the time allowed between pongs before the session is closed
+	wsPongWait = 60 * time.Second
+	// wsPingInterval is how often pings are sent, it must be less than wsPongWait
+	wsPingInterval = 30 * time.Second
+	// wsReadLimit is the largest message accepted from the client
+	wsReadLimit = 15 * 1024 * 1024
+)
+
 var wsPool = new(sync.Pool)
 
-// ServeWS starts a seperate websocket server for ethereum json rpc pub/sub
+// ServeWS starts a seperate websocket server for ethereum json rpc pub/sub. The
+// server's main address also accepts websockets, so this is only needed to serve
+// them on a different port.
 func (s *Server) ServeWS(endpoint string) error {
 	lstnr, err := net.Listen("tcp", endpoint)
 	if err != nil {
 		return err
 	}
-	srv := http.Server{Handler: s.wsHandler()}
+	srv := http.Server{Handler: s.authorize(s.wsHandler())}
 	return srv.Serve(lstnr)
 }
 
-// wsHandler is the main handler for issuing subscriptions via websocket
+// wsHandler upgrades each connection into a json rpc session, where any method can
+// be called and any number of subscriptions can be made
 func (s *Server) wsHandler() http.Handler {
 	var upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 		WriteBufferPool: wsPool,
-		CheckOrigin:     originValidator,
+		CheckOrigin: func(r *http.Request) bool {
+			return s.policy.allowOrigin(r.Header.Get("Origin"))
+		},
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// upgrade the connection
@@ -40,26 +55,98 @@ func (s *Server) wsHandler() http.Handler {
 			fmt.Println("websocket upgrade failed", err)
 			return
 		}
-		var msg rpcMessage
-		err = conn.ReadJSON(&msg)
+		newWSSession(s, conn, roleOf(r)).run()
+	})
+}
+
+// isWebsocket matches requests asking to upgrade to a websocket
+func isWebsocket(r *http.Request, _ *mux.RouteMatch) bool {
+	return websocket.IsWebSocketUpgrade(r)
+}
+
+////////////////////////////////
+//	Websocket Sessions
+//////////////////////////////
+
+// wsSession serves a session over a websocket connection, which is kept alive
+// using pings
+type wsSession struct {
+	*session
+	conn *websocket.Conn
+}
+
+func newWSSession(s *Server, conn *websocket.Conn, role permission) *wsSession {
+	return &wsSession{
+		session: newSession(s, role),
+		conn:    conn,
+	}
+}
+
+// run serves the session until the client leaves, stops answering pings, or the
+// server shuts down. Every subscription is cancelled before returning.
+func (sess *wsSession) run() {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sess.writeLoop()
+	}()
+	sess.readLoop()
+	sess.cancel()
+	wg.Wait()
+	sess.conn.Close()
+}
+
+// readLoop handles requests until the connection fails
+func (sess *wsSession) readLoop() {
+	sess.conn.SetReadLimit(wsReadLimit)
+	sess.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	sess.conn.SetPongHandler(func(string) error {
+		return sess.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	defer sess.startFeeds()
+	for {
+		_, body, err := sess.conn.ReadMessage()
 		if err != nil {
-			fmt.Println("error reading json with ws:", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println("websocket read error:", err)
+			}
+			return
 		}
-		rawPrms := string(msg.Params)
-		switch {
-		case strings.Contains(rawPrms, "logs"):
-			ctx, _ := context.WithTimeout(s.ctx, time.Hour)
-			fmt.Println("subscribing to logs")
-			subLogs(ctx, s.back, conn, json.RawMessage(rawPrms))
-		case strings.Contains(rawPrms, "newHeads"):
-			ctx, _ := context.WithTimeout(s.ctx, time.Hour)
-			subHeads(ctx, s.back, conn)
-		default:
-			conn.Close()
+		if sess.handle(body) != nil {
+			return
 		}
-	})
+	}
 }
 
-func originValidator(*http.Request) bool {
-	return true
+// writeLoop writes queued frames and keeps the connection alive with pings
+func (sess *wsSession) writeLoop() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sess.ctx.Done():
+			sess.conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(wsWriteWait),
+			)
+			return
+		case msg := <-sess.send:
+			sess.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := sess.conn.WriteMessage(websocket.TextMessage, msg)
+			if err != nil {
+				log.Println("websocket write error:", err)
+				sess.cancel()
+				return
+			}
+		case <-ticker.C:
+			sess.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := sess.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				sess.cancel()
+				return
+			}
+		}
+	}
 }
@@ -3,6 +3,8 @@ package thereum
 import (
 	"context"
 	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"math/big"
@@ -71,7 +73,30 @@ func NewAccount(name string, bal *big.Int) (*Account, error) {
 		fmt.Println("COULD NOT GENERATE PRIVATE KEY FOR: ", name)
 		return nil, err
 	}
+	return newAccount(name, priv, bal)
+}
+
+// NewSeededAccount issues a new account with a private key derived from the seed and
+// the account's name, so the same seed and name always make the same account
+func NewSeededAccount(seed, name string, bal *big.Int) (*Account, error) {
+	priv, err := crypto.ToECDSA(crypto.Keccak256([]byte(seed), []byte(name)))
+	if err != nil {
+		return nil, fmt.Errorf("could not derive private key for %s: %s", name, err)
+	}
+	return newAccount(name, priv, bal)
+}
+
+// RandomSeed issues a random seed for deriving accounts with NewSeededAccount
+func RandomSeed() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("could not generate seed: %s", err)
+	}
+	return hex.EncodeToString(b), nil
+}
 
+func newAccount(name string, priv *ecdsa.PrivateKey, bal *big.Int) (*Account, error) {
 	topt := bind.NewKeyedTransactor(priv)
 	topt.Nonce = big.NewInt(0)
 	topt.GasLimit = 21000
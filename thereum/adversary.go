@@ -0,0 +1,298 @@
+package thereum
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/evan-forbes/ethlab/txpool"
+)
+
+// Adversary watches transactions as they're added to the txpool, and can respond by
+// placing its own transactions directly before and after them in the same block.
+// This simulates front-running, back-running, and sandwich attacks.
+type Adversary interface {
+	// Name identifies the adversary in attack reports
+	Name() string
+	// Attack is called with each valid transaction passed to AddTx. Returning a nil
+	// Attack lets the victim through to the txpool untouched.
+	Attack(env *AttackEnv, victim *types.Transaction) (*Attack, error)
+}
+
+// Attack describes the transactions an adversary wants placed around a victim
+type Attack struct {
+	Front       []*types.Transaction // Front is executed directly before the victim
+	Back        []*types.Transaction // Back is executed directly after the victim
+	Beneficiary common.Address       // profit is the change in the beneficiary's balance
+}
+
+// AttackReport records the outcome of an attack
+type AttackReport struct {
+	Adversary   string
+	Victim      common.Hash
+	Bundle      common.Hash // Bundle is empty if the attack failed before it was bundled
+	BlockNumber *big.Int
+	Profit      *big.Int // Profit is measured in wei, after gas costs, and can be negative
+	Err         error    // Err is set if the attack could not be made or included in a block
+}
+
+// attack is an attack waiting to be included in a block
+type attack struct {
+	adversary   string
+	victim      *types.Transaction
+	beneficiary common.Address
+	bundle      *txpool.Bundle
+}
+
+// adversaries keeps track of the registered adversaries and their attacks
+type adversaries struct {
+	list    []Adversary
+	pending map[common.Hash]*attack // keyed by bundle hash
+	reports []*AttackReport
+	mu      sync.Mutex
+}
+
+func newAdversaries() *adversaries {
+	return &adversaries{pending: make(map[common.Hash]*attack)}
+}
+
+// registered returns the registered adversaries
+func (a *adversaries) registered() []Adversary {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]Adversary, len(a.list))
+	copy(out, a.list)
+	return out
+}
+
+// lookup finds the pending attack using the bundle with the provided hash
+func (a *adversaries) lookup(hash common.Hash) (*attack, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	atk, has := a.pending[hash]
+	return atk, has
+}
+
+// finish removes the attack from the pending attacks and records its report
+func (a *adversaries) finish(atk *attack, report *AttackReport) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.pending, atk.bundle.Hash())
+	a.reports = append(a.reports, report)
+}
+
+// fail records the report of an attack that failed before it was bundled
+func (a *adversaries) fail(adv Adversary, victim *types.Transaction, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reports = append(a.reports, &AttackReport{
+		Adversary: adv.Name(),
+		Victim:    victim.Hash(),
+		Err:       err,
+	})
+}
+
+// pendingNonces counts the transactions addr has waiting in pending attacks
+func (a *adversaries) pendingNonces(addr common.Address, signer types.Signer) uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var count uint64
+	for _, atk := range a.pending {
+		for _, tx := range atk.bundle.Transactions {
+			if tx == atk.victim {
+				continue
+			}
+			from, err := types.Sender(signer, tx)
+			if err == nil && from == addr {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// AttackEnv gives adversaries a read only view of the chain
+type AttackEnv struct {
+	eth *Thereum
+}
+
+// State returns a copy of the latest state
+func (env *AttackEnv) State() *state.StateDB {
+	return env.eth.LatestState()
+}
+
+// Nonce returns the next nonce addr can use, counting any transactions it already
+// has waiting in an attack
+func (env *AttackEnv) Nonce(addr common.Address) uint64 {
+	nonce, _ := env.eth.GetNonce(addr)
+	return nonce + env.eth.adversaries.pendingNonces(addr, env.eth.signer)
+}
+
+// Simulate applies the transactions, in order, on top of the latest block
+func (env *AttackEnv) Simulate(txs ...*types.Transaction) (*Simulation, error) {
+	return env.eth.Simulate(txs...)
+}
+
+////////////////////////////////////
+// 		Running Adversaries
+//////////////////////////////////
+
+// AddAdversary registers an adversary. Each transaction added with AddTx is shown to
+// the adversaries in the order they were added, until one of them attacks it.
+func (t *Thereum) AddAdversary(adv Adversary) {
+	t.adversaries.mu.Lock()
+	defer t.adversaries.mu.Unlock()
+	t.adversaries.list = append(t.adversaries.list, adv)
+}
+
+// AttackReports returns the outcome of every attack that has left the txpool, as well
+// as every attack that couldn't be made
+func (t *Thereum) AttackReports() []*AttackReport {
+	t.adversaries.mu.Lock()
+	defer t.adversaries.mu.Unlock()
+	out := make([]*AttackReport, len(t.adversaries.reports))
+	copy(out, t.adversaries.reports)
+	return out
+}
+
+// AdversaryProfit sums the profit of every included attack made by the named adversary
+func (t *Thereum) AdversaryProfit(name string) *big.Int {
+	profit := new(big.Int)
+	for _, report := range t.AttackReports() {
+		if report.Adversary == name && report.Err == nil {
+			profit.Add(profit, report.Profit)
+		}
+	}
+	return profit
+}
+
+// errVictimLeft is reported when the victim is evicted or replaced before it can be
+// bundled with the adversary's transactions
+var errVictimLeft = errors.New("victim left the txpool")
+
+// attack shows the pooled victim to each adversary. If one of them attacks, the
+// adversary's transactions are pooled under the same rules as any other, and then
+// moved out of the pool with the victim into a bundle, so they're placed around it.
+// True is returned if the victim was bundled. Victims that can't be executed yet
+// cause the attack to fail, which returns them to the pool.
+func (t *Thereum) attack(from common.Address, victim *types.Transaction) bool {
+	advs := t.adversaries.registered()
+	if len(advs) == 0 || t.bundles == nil {
+		return false
+	}
+	env := &AttackEnv{eth: t}
+	for _, adv := range advs {
+		atk, err := adv.Attack(env, victim)
+		if err != nil {
+			t.adversaries.fail(adv, victim, err)
+			continue
+		}
+		if atk == nil || len(atk.Front)+len(atk.Back) == 0 {
+			continue
+		}
+		own := make([]*types.Transaction, 0, len(atk.Front)+len(atk.Back))
+		own = append(own, atk.Front...)
+		own = append(own, atk.Back...)
+		err = t.poolAttack(own)
+		if err != nil {
+			t.adversaries.fail(adv, victim, err)
+			continue
+		}
+		txs := make([]*types.Transaction, 0, len(own)+1)
+		txs = append(txs, atk.Front...)
+		txs = append(txs, victim)
+		txs = append(txs, atk.Back...)
+		// the victim can be evicted or replaced while the adversary's txs are pooled
+		if !t.bundles.Take(txs) {
+			t.withdraw(own)
+			t.adversaries.fail(adv, victim, errVictimLeft)
+			continue
+		}
+		bundle := &txpool.Bundle{Transactions: txs}
+		t.adversaries.mu.Lock()
+		err = t.bundles.AddBundle(bundle)
+		if err == nil {
+			t.adversaries.pending[bundle.Hash()] = &attack{
+				adversary:   adv.Name(),
+				victim:      victim,
+				beneficiary: atk.Beneficiary,
+				bundle:      bundle,
+			}
+		}
+		t.adversaries.mu.Unlock()
+		if err != nil {
+			t.adversaries.fail(adv, victim, err)
+			t.discard(own, err)
+			t.txPool.Restore([]*types.Transaction{victim})
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// poolAttack inserts an adversary's transactions into the pool one at a time. If
+// any of them is rejected, the ones already inserted are withdrawn.
+func (t *Thereum) poolAttack(txs []*types.Transaction) error {
+	for i, tx := range txs {
+		from, err := types.Sender(t.signer, tx)
+		if err == nil {
+			err = t.txPool.Insert(from, tx)
+		}
+		if err != nil {
+			t.withdraw(txs[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// withdraw takes each of the transactions out of the pool, skipping any that have
+// already left it
+func (t *Thereum) withdraw(txs []*types.Transaction) {
+	for _, tx := range txs {
+		t.bundles.Take([]*types.Transaction{tx})
+	}
+}
+
+// applyAttack places an attack bundle into the block, reporting the adversary's
+// profit. Failed attacks are discarded, but the victim is returned to the txpool.
+func (t *Thereum) applyAttack(builder *blockBuilder, atk *attack) error {
+	before := builder.state.GetBalance(atk.beneficiary)
+	err := builder.applyBundle(atk.bundle)
+	report := &AttackReport{
+		Adversary:   atk.adversary,
+		Victim:      atk.victim.Hash(),
+		Bundle:      atk.bundle.Hash(),
+		BlockNumber: new(big.Int).Set(builder.Number()),
+		Err:         err,
+	}
+	if err != nil {
+		t.failAttack(atk, err)
+		return err
+	}
+	report.Profit = new(big.Int).Sub(builder.state.GetBalance(atk.beneficiary), before)
+	t.adversaries.finish(atk, report)
+	return nil
+}
+
+// failAttack discards the adversary's transactions and returns the victim to the pool
+func (t *Thereum) failAttack(atk *attack, reason error) {
+	var txs []*types.Transaction
+	for _, tx := range atk.bundle.Transactions {
+		if tx != atk.victim {
+			txs = append(txs, tx)
+		}
+	}
+	t.discard(txs, reason)
+	t.txPool.Restore([]*types.Transaction{atk.victim})
+	t.adversaries.finish(atk, &AttackReport{
+		Adversary: atk.adversary,
+		Victim:    atk.victim.Hash(),
+		Bundle:    atk.bundle.Hash(),
+		Err:       reason,
+	})
+}
@@ -0,0 +1,166 @@
+package thereum
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/bitutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/bloombits"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// the bloom bits index is built and served the same way geth does it, see
+// go-ethereum/eth/bloombits.go
+const (
+	// bloomServiceThreads is the number of goroutines used to service bloombits
+	// lookups for all running filters.
+	bloomServiceThreads = 16
+
+	// bloomFilterThreads is the number of goroutines used locally per filter to
+	// multiplex requests onto the global servicing goroutines.
+	bloomFilterThreads = 3
+
+	// bloomRetrievalBatch is the maximum number of bloom bit retrievals to service
+	// in a single batch.
+	bloomRetrievalBatch = 16
+
+	// bloomRetrievalWait is the maximum time to wait for enough bloom bit requests
+	// to accumulate request an entire batch.
+	bloomRetrievalWait = time.Duration(0)
+
+	// bloomConfirms is the number of blocks to wait before indexing a section. Thereum
+	// never reorgs, so sections are indexed as soon as they're complete.
+	bloomConfirms = 0
+
+	// bloomThrottling is the time to wait between indexing two sections. The database
+	// is kept in memory, so there's little need to wait.
+	bloomThrottling = 10 * time.Millisecond
+)
+
+// bloomService indexes the header blooms of the chain in sections, and serves the
+// index to log filters
+type bloomService struct {
+	db       ethdb.Database
+	size     uint64 // size is the number of blocks in each section
+	indexer  *core.ChainIndexer
+	requests chan chan *bloombits.Retrieval
+	quit     chan struct{}
+}
+
+// newBloomService starts indexing the chain and serving bloom bits lookups
+func newBloomService(db ethdb.Database, chain *core.BlockChain, size uint64) *bloomService {
+	bs := &bloomService{
+		db:       db,
+		size:     size,
+		indexer:  newBloomIndexer(db, size),
+		requests: make(chan chan *bloombits.Retrieval),
+		quit:     make(chan struct{}),
+	}
+	bs.indexer.Start(chain)
+	for i := 0; i < bloomServiceThreads; i++ {
+		go bs.serve()
+	}
+	return bs
+}
+
+// serve accepts bloom bit retrievals from any filter and fills them from the database
+func (bs *bloomService) serve() {
+	for {
+		select {
+		case <-bs.quit:
+			return
+
+		case request := <-bs.requests:
+			task := <-request
+			task.Bitsets = make([][]byte, len(task.Sections))
+			for i, section := range task.Sections {
+				head := rawdb.ReadCanonicalHash(bs.db, (section+1)*bs.size-1)
+				compVector, err := rawdb.ReadBloomBits(bs.db, task.Bit, section, head)
+				if err != nil {
+					task.Error = err
+					continue
+				}
+				blob, err := bitutil.DecompressBytes(compVector, int(bs.size/8))
+				if err != nil {
+					task.Error = err
+					continue
+				}
+				task.Bitsets[i] = blob
+			}
+			request <- task
+		}
+	}
+}
+
+// status returns the section size and the number of indexed sections
+func (bs *bloomService) status() (uint64, uint64) {
+	sections, _, _ := bs.indexer.Sections()
+	return bs.size, sections
+}
+
+// service multiplexes a filter's retrievals onto the serving goroutines
+func (bs *bloomService) service(ctx context.Context, session *bloombits.MatcherSession) {
+	for i := 0; i < bloomFilterThreads; i++ {
+		go session.Multiplex(bloomRetrievalBatch, bloomRetrievalWait, bs.requests)
+	}
+}
+
+// close stops indexing and serving
+func (bs *bloomService) close() error {
+	close(bs.quit)
+	return bs.indexer.Close()
+}
+
+// bloomIndexer implements core.ChainIndexerBackend, building a rotated bloom bits
+// index from the header bloom filters
+type bloomIndexer struct {
+	size    uint64               // section size to generate bloombits for
+	db      ethdb.Database       // database instance to write index data and metadata into
+	gen     *bloombits.Generator // generator to rotate the bloom bits crating the bloom index
+	section uint64               // section is the section number being processed currently
+	head    common.Hash          // head is the hash of the last header processed
+}
+
+// newBloomIndexer returns a chain indexer that generates bloom bits data for the
+// canonical chain
+func newBloomIndexer(db ethdb.Database, size uint64) *core.ChainIndexer {
+	backend := &bloomIndexer{
+		db:   db,
+		size: size,
+	}
+	table := rawdb.NewTable(db, string(rawdb.BloomBitsIndexPrefix))
+	return core.NewChainIndexer(db, table, backend, size, bloomConfirms, bloomThrottling, "bloombits")
+}
+
+// Reset implements core.ChainIndexerBackend, starting a new bloombits index section
+func (b *bloomIndexer) Reset(ctx context.Context, section uint64, lastSectionHead common.Hash) error {
+	gen, err := bloombits.NewGenerator(uint(b.size))
+	b.gen, b.section, b.head = gen, section, common.Hash{}
+	return err
+}
+
+// Process implements core.ChainIndexerBackend, adding a new header's bloom into the
+// index
+func (b *bloomIndexer) Process(ctx context.Context, header *types.Header) error {
+	b.gen.AddBloom(uint(header.Number.Uint64()-b.section*b.size), header.Bloom)
+	b.head = header.Hash()
+	return nil
+}
+
+// Commit implements core.ChainIndexerBackend, finalizing the bloom section and
+// writing it out into the database
+func (b *bloomIndexer) Commit() error {
+	batch := b.db.NewBatch()
+	for i := 0; i < types.BloomBitLength; i++ {
+		bits, err := b.gen.Bitset(uint(i))
+		if err != nil {
+			return err
+		}
+		rawdb.WriteBloomBits(batch, uint(i), b.section, b.head, bitutil.CompressBytes(bits))
+	}
+	return batch.Write()
+}
@@ -0,0 +1,179 @@
+package thereum
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/evan-forbes/ethlab/txpool"
+)
+
+// blockBuilder assembles a block on top of a parent one transaction at a time. Unlike
+// core.GenerateChain, transactions that can't be applied are left out of the block
+// instead of causing a panic, and bundles of transactions can be reverted together.
+type blockBuilder struct {
+	config   *params.ChainConfig
+	chain    *core.BlockChain
+	engine   consensus.Engine
+	header   *types.Header
+	state    *state.StateDB
+	gasPool  *core.GasPool
+	txs      []*types.Transaction
+	receipts []*types.Receipt
+}
+
+// newBlockBuilder starts a new block on top of parent, paying rewards to coinbase
+func newBlockBuilder(config *params.ChainConfig, chain *core.BlockChain, engine consensus.Engine, db ethdb.Database, parent *types.Block, coinbase common.Address) (*blockBuilder, error) {
+	statedb, err := state.New(parent.Root(), state.NewDatabase(db))
+	if err != nil {
+		return nil, err
+	}
+	header := makeHeader(chain, parent, statedb, engine)
+	header.Coinbase = coinbase
+	return &blockBuilder{
+		config:  config,
+		chain:   chain,
+		engine:  engine,
+		header:  header,
+		state:   statedb,
+		gasPool: new(core.GasPool).AddGas(header.GasLimit),
+	}, nil
+}
+
+// blockTime is the fixed number of seconds between blocks
+const blockTime = 10
+
+// nextBlockTime returns the timestamp of the block after parent. Block times start
+// at 0 with the genesis block and grow by blockTime with each block, so chain time
+// is unrelated to wall clock time.
+func nextBlockTime(parent *types.Block) uint64 {
+	return parent.Time() + blockTime
+}
+
+// makeHeader creates the header of the next block the same way core.GenerateChain
+// does, using a fixed block time.
+func makeHeader(chain consensus.ChainReader, parent *types.Block, state *state.StateDB, engine consensus.Engine) *types.Header {
+	time := nextBlockTime(parent)
+
+	return &types.Header{
+		Root:       state.IntermediateRoot(chain.Config().IsEIP158(parent.Number())),
+		ParentHash: parent.Hash(),
+		Coinbase:   parent.Coinbase(),
+		Difficulty: engine.CalcDifficulty(chain, time, &types.Header{
+			Number:     parent.Number(),
+			Time:       time - blockTime,
+			Difficulty: parent.Difficulty(),
+			UncleHash:  parent.UncleHash(),
+		}),
+		GasLimit: core.CalcGasLimit(parent, parent.GasLimit(), parent.GasLimit()),
+		Number:   new(big.Int).Add(parent.Number(), common.Big1),
+		Time:     time,
+	}
+}
+
+// copy returns a builder that continues from the same point without affecting this one
+func (b *blockBuilder) copy() *blockBuilder {
+	gas := *b.gasPool
+	return &blockBuilder{
+		config:   b.config,
+		chain:    b.chain,
+		engine:   b.engine,
+		header:   types.CopyHeader(b.header),
+		state:    b.state.Copy(),
+		gasPool:  &gas,
+		txs:      append([]*types.Transaction(nil), b.txs...),
+		receipts: append([]*types.Receipt(nil), b.receipts...),
+	}
+}
+
+// Number returns the number of the block being built
+func (b *blockBuilder) Number() *big.Int {
+	return b.header.Number
+}
+
+// GasLeft returns the amount of gas left in the block
+func (b *blockBuilder) GasLeft() uint64 {
+	return b.gasPool.Gas()
+}
+
+// apply runs the transaction on the block's state, leaving the state untouched if
+// the transaction can't be applied.
+func (b *blockBuilder) apply(tx *types.Transaction) (*types.Receipt, error) {
+	snap := b.state.Snapshot()
+	gas := b.gasPool.Gas()
+	b.state.Prepare(tx.Hash(), common.Hash{}, len(b.txs))
+	receipt, err := core.ApplyTransaction(b.config, b.chain, &b.header.Coinbase, b.gasPool, b.state, b.header, tx, &b.header.GasUsed, vm.Config{})
+	if err != nil {
+		b.state.RevertToSnapshot(snap)
+		b.gasPool = new(core.GasPool).AddGas(gas)
+		return nil, err
+	}
+	b.txs = append(b.txs, tx)
+	b.receipts = append(b.receipts, receipt)
+	return receipt, nil
+}
+
+// applyBundle runs the bundle's transactions one after another. If any transaction
+// can't be applied, or fails while the bundle is set to RevertOnFail, the entire
+// bundle is left out of the block.
+func (b *blockBuilder) applyBundle(bundle *txpool.Bundle) error {
+	// the state's journal is cleared after each transaction, so copy it instead of
+	// relying on snapshots
+	backup := b.state.Copy()
+	gas, used, count := b.gasPool.Gas(), b.header.GasUsed, len(b.txs)
+	for _, tx := range bundle.Transactions {
+		receipt, err := b.apply(tx)
+		if err == nil && bundle.RevertOnFail && receipt.Status == types.ReceiptStatusFailed {
+			err = txpool.ErrBundleReverted
+		}
+		if err != nil {
+			b.state = backup
+			b.gasPool = new(core.GasPool).AddGas(gas)
+			b.header.GasUsed = used
+			b.txs = b.txs[:count]
+			b.receipts = b.receipts[:count]
+			return err
+		}
+	}
+	return nil
+}
+
+// finalize assembles the block and writes its state to the database
+func (b *blockBuilder) finalize() (*types.Block, error) {
+	block, err := b.engine.FinalizeAndAssemble(b.chain, b.header, b.state, b.txs, nil, b.receipts)
+	if err != nil {
+		return nil, err
+	}
+	root, err := b.state.Commit(b.config.IsEIP158(b.header.Number))
+	if err != nil {
+		return nil, err
+	}
+	err = b.state.Database().TrieDB().Commit(root, false)
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// call executes a read only message call against the block's state
+func (b *blockBuilder) call(from common.Address, to common.Address, data []byte) ([]byte, error) {
+	snap := b.state.Snapshot()
+	defer b.state.RevertToSnapshot(snap)
+	msg := types.NewMessage(from, &to, 0, new(big.Int), b.header.GasLimit, new(big.Int), data, false)
+	evm := vm.NewEVM(core.NewEVMContext(msg, b.header, b.chain, &b.header.Coinbase), b.state, b.config, vm.Config{})
+	ret, _, failed, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(b.header.GasLimit))
+	if err != nil {
+		return nil, err
+	}
+	if failed {
+		return ret, errors.New("execution reverted")
+	}
+	return ret, nil
+}
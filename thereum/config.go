@@ -12,6 +12,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/evan-forbes/ethlab/txpool"
 )
 
 // Config contains the standard variables for creating a new Thereum chain/node
@@ -21,10 +22,42 @@ type Config struct {
 	Allocation    map[string]string `json:"allocation"` // "Name": "100000000000000000"
 	GasLimit      uint64            `json:"gas_limit"`
 	Delay         uint
-	Host          string `json:"host"`
-	Port          uint   `json:"port"`
-	WSHost        string `json:"ws_host"`
-	WSPort        uint   `json:"ws_port"`
+	Host          string        `json:"host"`
+	Port          uint          `json:"port"`
+	WSHost        string        `json:"ws_host"`
+	WSPort        uint          `json:"ws_port"`  // WSPort also serves websockets on their own port, which is skipped if it's 0 or Port
+	IPCPath       string        `json:"ipc_path"` // IPCPath is the unix socket used to serve json rpc, which isn't served if empty
+	TxPool        txpool.Config `json:"txpool"`
+	Pool          txpool.Pooler `json:"-"`              // Pool overrides the LinkedPool built using TxPool
+	BloomSection  uint64        `json:"bloom_section"`  // BloomSection is the number of blocks in each section of the log index
+	FilterTimeout uint          `json:"filter_timeout"` // FilterTimeout is the number of seconds a polling filter lives without being polled
+	Seed          string        `json:"seed"`           // Seed derives the accounts' private keys, which are random if empty
+	RecordPath    string        `json:"record_path"`    // RecordPath is the jsonl file json rpc traffic is recorded to, nothing is recorded if empty. A random Seed is used if there isn't one, and it's written into the recording for replays
+	Auth          AuthConfig    `json:"auth"`
+	Faucet        FaucetConfig  `json:"faucet"`
+}
+
+// AuthConfig controls who can use the rpc server. The zero value lets anyone call
+// any method from any origin.
+type AuthConfig struct {
+	Origins    []string          `json:"origins"`    // Origins that browsers can make requests from, "*" allows any. Any are allowed if empty
+	JWTSecret  string            `json:"jwt_secret"` // JWTSecret is the HS256 shared secret used to verify bearer tokens, hex if prefixed with 0x
+	Namespaces map[string]string `json:"namespaces"` // Namespaces maps method namespaces, such as "eth", to "public" or "admin". "*" sets the default
+}
+
+// FaucetConfig limits the eth sent by the faucet. Amounts are decimal strings of wei,
+// like the allocation, and cooldowns are in seconds. Limits left empty or zero
+// don't limit anything.
+type FaucetConfig struct {
+	MaxDrip         string   `json:"max_drip"`          // MaxDrip is the most wei sent per request
+	AddressCooldown uint     `json:"address_cooldown"`  // AddressCooldown is the time between requests for the same address
+	IPCooldown      uint     `json:"ip_cooldown"`       // IPCooldown is the time between requests from the same ip
+	AddressDailyCap string   `json:"address_daily_cap"` // AddressDailyCap is the most wei an address can receive each day
+	IPDailyCap      string   `json:"ip_daily_cap"`      // IPDailyCap is the most wei sent to requests from the same ip each day
+	Allow           []string `json:"allow"`             // Allow only lets these addresses receive eth, if not empty
+	Deny            []string `json:"deny"`              // Deny stops these addresses from receiving eth
+	Budget          string   `json:"budget"`            // Budget is the most wei the faucet sends in total
+	ReceiptTimeout  uint     `json:"receipt_timeout"`   // ReceiptTimeout is the seconds to wait for the eth sent to be mined, 15 if zero, and less than the server's 20 second write timeout
 }
 
 // ConfigFromFile opens and decodes a config.json file
@@ -38,6 +71,11 @@ func ConfigFromFile(path string) (Config, error) {
 	return out, err
 }
 
+// SeparateWS reports if websockets should also be served on their own port
+func (c Config) SeparateWS() bool {
+	return c.WSPort != 0 && (c.WSPort != c.Port || c.WSHost != c.Host)
+}
+
 // DB returns the proper database specified by the config
 // currently only supports in memory databases
 func (c Config) DB() ethdb.Database {
@@ -57,6 +95,14 @@ func (c Config) DB() ethdb.Database {
 // 	return &ConstantGasLimit{limit: out}
 // }
 
+// NewAccount issues a new account, derived from the config's seed if it has one
+func (c Config) NewAccount(name string, bal *big.Int) (*Account, error) {
+	if c.Seed != "" {
+		return NewSeededAccount(c.Seed, name, bal)
+	}
+	return NewAccount(name, bal)
+}
+
 // Genesis issues a new genesis configuration specified in the config
 func (c Config) Genesis() (core.Genesis, Accounts, error) {
 	var out core.Genesis
@@ -70,7 +116,7 @@ func (c Config) Genesis() (core.Genesis, Accounts, error) {
 		if !ok {
 			err = errors.New("could set string balance during genesis allocations")
 		}
-		acc, aerr := NewAccount(name, bal)
+		acc, aerr := c.NewAccount(name, bal)
 		if aerr != nil {
 			fmt.Println("problem making new account for", name, bal.String(), aerr)
 			err = aerr
@@ -104,11 +150,14 @@ func DefaultConfig() Config {
 		Allocation: map[string]string{
 			"root": "999999999999999999999999999999999",
 		},
-		GasLimit: 9000485760,
-		Delay:    50,
-		Host:     "127.0.0.1",
-		Port:     8438,
-		WSHost:   "127.0.0.1",
-		WSPort:   8439,
+		GasLimit:      9000485760,
+		Delay:         50,
+		Host:          "127.0.0.1",
+		Port:          8438,
+		WSHost:        "127.0.0.1",
+		WSPort:        8439,
+		TxPool:        txpool.DefaultConfig(),
+		BloomSection:  params.BloomBitsBlocks,
+		FilterTimeout: 300,
 	}
 }
@@ -2,6 +2,7 @@ package thereum
 
 import (
 	"context"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
@@ -13,11 +14,13 @@ import (
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
-// filterBackend implements filters.Backend to support filtering for logs without
-// taking bloom-bits acceleration structures into account.
+// filterBackend implements filters.Backend to support filtering for logs, using the
+// bloom bits index to speed up searches over indexed sections of the chain.
 type filterBackend struct {
-	db ethdb.Database
-	bc *core.BlockChain
+	db     ethdb.Database
+	bc     *core.BlockChain
+	bloom  *bloomService
+	txFeed *txFeed
 }
 
 func (fb *filterBackend) ChainDb() ethdb.Database  { return fb.db }
@@ -59,7 +62,7 @@ func (fb *filterBackend) GetLogs(ctx context.Context, hash common.Hash) ([][]*ty
 }
 
 func (fb *filterBackend) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription {
-	return nullSubscription()
+	return fb.txFeed.Subscribe(ch)
 }
 
 func (fb *filterBackend) SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription {
@@ -78,10 +81,10 @@ func (fb *filterBackend) SubscribePendingLogsEvent(ch chan<- []*types.Log) event
 	return nullSubscription()
 }
 
-func (fb *filterBackend) BloomStatus() (uint64, uint64) { return 4096, 0 }
+func (fb *filterBackend) BloomStatus() (uint64, uint64) { return fb.bloom.status() }
 
 func (fb *filterBackend) ServiceFilter(ctx context.Context, ms *bloombits.MatcherSession) {
-	panic("not supported")
+	fb.bloom.service(ctx, ms)
 }
 
 func nullSubscription() event.Subscription {
@@ -90,3 +93,45 @@ func nullSubscription() event.Subscription {
 		return nil
 	})
 }
+
+// txFeed delivers new transactions to each subscribed channel without waiting on
+// them. event.Feed blocks until every subscriber has received, which would let one
+// slow reader stall AddTx, so a subscriber that isn't ready misses the event instead.
+type txFeed struct {
+	mu   sync.Mutex
+	subs map[chan<- core.NewTxsEvent]struct{}
+}
+
+func newTxFeed() *txFeed {
+	return &txFeed{subs: make(map[chan<- core.NewTxsEvent]struct{})}
+}
+
+// Subscribe adds a channel to the feed until the subscription is unsubscribed.
+// Buffer the channel to avoid missing events that arrive in bursts.
+func (f *txFeed) Subscribe(ch chan<- core.NewTxsEvent) event.Subscription {
+	f.mu.Lock()
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		f.mu.Lock()
+		delete(f.subs, ch)
+		f.mu.Unlock()
+		return nil
+	})
+}
+
+// Send offers the event to each subscriber, returning the number that received it
+func (f *txFeed) Send(ev core.NewTxsEvent) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sent := 0
+	for ch := range f.subs {
+		select {
+		case ch <- ev:
+			sent++
+		default:
+		}
+	}
+	return sent
+}
@@ -0,0 +1,189 @@
+package thereum
+
+import (
+	"math/big"
+	"math/rand"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Invariant checks the state and receipts resulting from one ordering of a block's
+// transactions, returning an error if the invariant doesn't hold
+type Invariant func(state *state.StateDB, receipts []*types.Receipt) error
+
+// FuzzConfig describes how the transactions of each block are fuzzed
+type FuzzConfig struct {
+	Runs       int   // Runs is the number of orderings tried for each block
+	Seed       int64 // Seed is used for the first run, and incremented for each run after
+	Invariants []Invariant
+}
+
+// FuzzFailure describes an ordering of transactions that broke an invariant
+type FuzzFailure struct {
+	BlockNumber *big.Int
+	Seed        int64                // Seed reproduces the failing ordering using OrderBySeed
+	Ordering    []*types.Transaction // Ordering is the smallest failing ordering found
+	Invariant   int                  // Invariant is the index of the broken invariant
+	Err         error
+}
+
+// fuzzer holds the fuzzing config and any failures found
+type fuzzer struct {
+	config   *FuzzConfig
+	failures []*FuzzFailure
+	mu       sync.Mutex
+}
+
+// OrderBySeed shuffles the transactions using the seed. Transactions from the same
+// sender keep their relative order, so that their nonces can still be used.
+func OrderBySeed(txs []*types.Transaction, seed int64) []*types.Transaction {
+	signer := types.NewEIP155Signer(big.NewInt(1))
+	senders := make([]common.Address, len(txs))
+	bySender := make(map[common.Address][]*types.Transaction)
+	for i, tx := range txs {
+		from, _ := types.Sender(signer, tx)
+		senders[i] = from
+		bySender[from] = append(bySender[from], tx)
+	}
+	// shuffle which sender goes in each slot, then fill the slots in nonce order
+	out := make([]*types.Transaction, len(txs))
+	for i, j := range rand.New(rand.NewSource(seed)).Perm(len(txs)) {
+		from := senders[j]
+		out[i] = bySender[from][0]
+		bySender[from] = bySender[from][1:]
+	}
+	return out
+}
+
+////////////////////////////////////
+// 		Fuzzing Orderings
+//////////////////////////////////
+
+// Fuzz turns on fuzzing mode. Before each block's transactions are added, they're
+// executed in many orderings against copies of the block, after its bundles have been
+// placed, checking the invariants for each. Failures can be found using FuzzFailures. Passing a nil
+// config turns fuzzing mode off.
+func (t *Thereum) Fuzz(config *FuzzConfig) {
+	t.fuzzer.mu.Lock()
+	defer t.fuzzer.mu.Unlock()
+	t.fuzzer.config = config
+}
+
+// FuzzFailures returns the failures found while in fuzzing mode
+func (t *Thereum) FuzzFailures() []*FuzzFailure {
+	t.fuzzer.mu.Lock()
+	defer t.fuzzer.mu.Unlock()
+	out := make([]*FuzzFailure, len(t.fuzzer.failures))
+	copy(out, t.fuzzer.failures)
+	return out
+}
+
+// fuzzBlock fuzzes the transactions about to be added to the block being built by
+// base, if fuzzing mode is on. base isn't changed. Broken invariants are recorded
+// as failures, while an error means the block couldn't be fuzzed.
+func (t *Thereum) fuzzBlock(base *blockBuilder, txs []*types.Transaction) error {
+	t.fuzzer.mu.Lock()
+	config := t.fuzzer.config
+	t.fuzzer.mu.Unlock()
+	if config == nil || len(txs) < 2 {
+		return nil
+	}
+	failure, err := fuzzOrderings(base, txs, *config)
+	if err != nil || failure == nil {
+		return err
+	}
+	t.fuzzer.mu.Lock()
+	defer t.fuzzer.mu.Unlock()
+	t.fuzzer.failures = append(t.fuzzer.failures, failure)
+	return nil
+}
+
+// FuzzOrderings executes the transactions in the configured number of seeded orderings
+// on top of parent, and checks the invariants against each. The first ordering to
+// break an invariant is shrunk down to the fewest transactions that still break it.
+// A nil failure is returned if every ordering passed.
+func (t *Thereum) FuzzOrderings(parent *types.Block, txs []*types.Transaction, config FuzzConfig) (*FuzzFailure, error) {
+	base, err := t.newBuilder(parent)
+	if err != nil {
+		return nil, err
+	}
+	return fuzzOrderings(base, txs, config)
+}
+
+// newBuilder starts a block on top of parent
+func (t *Thereum) newBuilder(parent *types.Block) (*blockBuilder, error) {
+	return newBlockBuilder(
+		t.chainConfig,
+		t.blockchain,
+		ethash.NewFaker(),
+		t.database,
+		parent,
+		t.root.Address,
+	)
+}
+
+// fuzzOrderings runs FuzzOrderings using copies of base, which isn't changed
+func fuzzOrderings(base *blockBuilder, txs []*types.Transaction, config FuzzConfig) (*FuzzFailure, error) {
+	for i := 0; i < config.Runs; i++ {
+		seed := config.Seed + int64(i)
+		applied, broken := runOrdering(base, OrderBySeed(txs, seed), config.Invariants)
+		if broken == nil {
+			continue
+		}
+		failure := &FuzzFailure{
+			BlockNumber: new(big.Int).Set(base.Number()),
+			Seed:        seed,
+			Ordering:    applied,
+			Invariant:   broken.index,
+			Err:         broken.err,
+		}
+		shrink(base, failure, config.Invariants)
+		return failure, nil
+	}
+	return nil, nil
+}
+
+// brokenInvariant records which invariant failed and why
+type brokenInvariant struct {
+	index int
+	err   error
+}
+
+// runOrdering applies the transactions in order to a copy of base, and checks the
+// invariants. Transactions that can't be applied are skipped. The applied
+// transactions are returned along with the first broken invariant, if any.
+func runOrdering(base *blockBuilder, txs []*types.Transaction, invariants []Invariant) ([]*types.Transaction, *brokenInvariant) {
+	builder := base.copy()
+	for _, tx := range txs {
+		builder.apply(tx)
+	}
+	applied := builder.txs[len(base.txs):]
+	for i, invariant := range invariants {
+		err := invariant(builder.state, builder.receipts)
+		if err != nil {
+			return applied, &brokenInvariant{index: i, err: err}
+		}
+	}
+	return applied, nil
+}
+
+// shrink removes transactions from the failing ordering one at a time, keeping each
+// removal that still breaks the same invariant
+func shrink(base *blockBuilder, failure *FuzzFailure, invariants []Invariant) {
+	for i := 0; i < len(failure.Ordering); {
+		candidate := make([]*types.Transaction, 0, len(failure.Ordering)-1)
+		candidate = append(candidate, failure.Ordering[:i]...)
+		candidate = append(candidate, failure.Ordering[i+1:]...)
+		applied, broken := runOrdering(base, candidate, invariants)
+		if broken == nil || broken.index != failure.Invariant {
+			i++
+			continue
+		}
+		failure.Ordering = applied
+		failure.Err = broken.err
+	}
+}
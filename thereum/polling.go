@@ -0,0 +1,248 @@
+package thereum
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/filters"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// defaultFilterTimeout is how long a polling filter lives without being polled
+const defaultFilterTimeout = 5 * time.Minute
+
+// ErrFilterNotFound is returned when polling a filter that was never installed,
+// was uninstalled, or timed out
+var ErrFilterNotFound = errors.New("filter not found")
+
+// FilterKind is the type of event a polling filter collects
+type FilterKind int
+
+// the kinds of polling filters
+const (
+	LogFilter FilterKind = iota
+	BlockFilter
+	PendingTxFilter
+)
+
+// pollFilter collects events from an event system subscription until it is polled
+type pollFilter struct {
+	kind   FilterKind
+	crit   ethereum.FilterQuery // crit is only used by log filters
+	sub    *filters.Subscription
+	polled time.Time
+	hashes []common.Hash
+	logs   []*types.Log
+}
+
+// pollFilters keeps track of the installed polling filters, uninstalling any that
+// haven't been polled within the timeout
+type pollFilters struct {
+	timeout   time.Duration
+	installed map[rpc.ID]*pollFilter
+	quit      chan struct{}
+	mu        sync.Mutex
+}
+
+func newPollFilters(timeout time.Duration) *pollFilters {
+	if timeout == 0 {
+		timeout = defaultFilterTimeout
+	}
+	pf := &pollFilters{
+		timeout:   timeout,
+		installed: make(map[rpc.ID]*pollFilter),
+		quit:      make(chan struct{}),
+	}
+	go pf.timeoutLoop()
+	return pf
+}
+
+// timeoutLoop periodically uninstalls filters that haven't been polled in time
+func (pf *pollFilters) timeoutLoop() {
+	ticker := time.NewTicker(pf.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pf.quit:
+			return
+		case now := <-ticker.C:
+			pf.mu.Lock()
+			for id, f := range pf.installed {
+				if now.Sub(f.polled) >= pf.timeout {
+					f.sub.Unsubscribe()
+					delete(pf.installed, id)
+				}
+			}
+			pf.mu.Unlock()
+		}
+	}
+}
+
+// install starts collecting events for the filter until it is uninstalled
+func (pf *pollFilters) install(f *pollFilter, hashes <-chan []common.Hash, logs <-chan []*types.Log, headers <-chan *types.Header) rpc.ID {
+	f.polled = time.Now()
+	pf.mu.Lock()
+	pf.installed[f.sub.ID] = f
+	pf.mu.Unlock()
+	go func() {
+		for {
+			select {
+			case hs := <-hashes:
+				pf.mu.Lock()
+				f.hashes = append(f.hashes, hs...)
+				pf.mu.Unlock()
+			case head := <-headers:
+				pf.mu.Lock()
+				f.hashes = append(f.hashes, head.Hash())
+				pf.mu.Unlock()
+			case ls := <-logs:
+				pf.mu.Lock()
+				f.logs = append(f.logs, ls...)
+				pf.mu.Unlock()
+			case <-f.sub.Err():
+				return
+			}
+		}
+	}()
+	return f.sub.ID
+}
+
+// close uninstalls every filter and stops the timeout loop
+func (pf *pollFilters) close() {
+	close(pf.quit)
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	for id, f := range pf.installed {
+		f.sub.Unsubscribe()
+		delete(pf.installed, id)
+	}
+}
+
+////////////////////////////////////
+// 		Polling Filters
+//////////////////////////////////
+
+// NewLogFilter installs a polling filter collecting logs matching the criteria
+// from each new block
+func (t *Thereum) NewLogFilter(crit ethereum.FilterQuery) (rpc.ID, error) {
+	logs := make(chan []*types.Log)
+	sub, err := t.Events.SubscribeLogs(crit, logs)
+	if err != nil {
+		return "", err
+	}
+	return t.filters.install(&pollFilter{kind: LogFilter, crit: crit, sub: sub}, nil, logs, nil), nil
+}
+
+// NewBlockFilter installs a polling filter collecting the hash of each new block
+func (t *Thereum) NewBlockFilter() rpc.ID {
+	headers := make(chan *types.Header)
+	sub := t.Events.SubscribeNewHeads(headers)
+	return t.filters.install(&pollFilter{kind: BlockFilter, sub: sub}, nil, nil, headers)
+}
+
+// NewPendingTxFilter installs a polling filter collecting the hash of each
+// transaction added to the txpool
+func (t *Thereum) NewPendingTxFilter() rpc.ID {
+	hashes := make(chan []common.Hash)
+	sub := t.Events.SubscribePendingTxs(hashes)
+	return t.filters.install(&pollFilter{kind: PendingTxFilter, sub: sub}, hashes, nil, nil)
+}
+
+// FilterChanges returns the events collected by a filter since it was last polled.
+// Log filters return []*types.Log, block and pending transaction filters return
+// []common.Hash.
+func (t *Thereum) FilterChanges(id rpc.ID) (interface{}, error) {
+	t.filters.mu.Lock()
+	defer t.filters.mu.Unlock()
+	f, has := t.filters.installed[id]
+	if !has {
+		return nil, ErrFilterNotFound
+	}
+	f.polled = time.Now()
+	if f.kind == LogFilter {
+		logs := f.logs
+		f.logs = nil
+		if logs == nil {
+			logs = []*types.Log{}
+		}
+		return logs, nil
+	}
+	hashes := f.hashes
+	f.hashes = nil
+	if hashes == nil {
+		hashes = []common.Hash{}
+	}
+	return hashes, nil
+}
+
+// FilterLogs returns every log matching a log filter's criteria, not just the
+// changes since it was last polled
+func (t *Thereum) FilterLogs(ctx context.Context, id rpc.ID) ([]*types.Log, error) {
+	t.filters.mu.Lock()
+	f, has := t.filters.installed[id]
+	if has {
+		f.polled = time.Now()
+	}
+	t.filters.mu.Unlock()
+	if !has || f.kind != LogFilter {
+		return nil, ErrFilterNotFound
+	}
+	return t.Logs(ctx, f.crit)
+}
+
+// UninstallFilter removes a polling filter, reporting if it was installed
+func (t *Thereum) UninstallFilter(id rpc.ID) bool {
+	t.filters.mu.Lock()
+	defer t.filters.mu.Unlock()
+	f, has := t.filters.installed[id]
+	if !has {
+		return false
+	}
+	f.sub.Unsubscribe()
+	delete(t.filters.installed, id)
+	return true
+}
+
+// Logs searches the chain for logs matching the criteria. A block hash limits the
+// search to that block, otherwise the range between FromBlock and ToBlock is
+// searched, defaulting to the latest block.
+func (t *Thereum) Logs(ctx context.Context, crit ethereum.FilterQuery) ([]*types.Log, error) {
+	var filter *filters.Filter
+	if crit.BlockHash != nil {
+		if t.blockchain.GetHeaderByHash(*crit.BlockHash) == nil {
+			return nil, errors.New("unknown block")
+		}
+		filter = filters.NewBlockFilter(t.filterBackend, *crit.BlockHash, crit.Addresses, crit.Topics)
+	} else {
+		begin := filterRangeEnd(crit.FromBlock)
+		end := filterRangeEnd(crit.ToBlock)
+		if begin >= 0 && end >= 0 && begin > end {
+			return nil, errors.New("fromBlock is after toBlock")
+		}
+		filter = filters.NewRangeFilter(t.filterBackend, begin, end, crit.Addresses, crit.Topics)
+	}
+	logs, err := filter.Logs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if logs == nil {
+		logs = []*types.Log{}
+	}
+	return logs, nil
+}
+
+// filterRangeEnd converts one end of a filter's block range for filters.NewRangeFilter,
+// which uses -1 for the latest block. Thereum has no pending logs, so pending is
+// treated as latest.
+func filterRangeEnd(number *big.Int) int64 {
+	if number == nil || number.Sign() < 0 {
+		return rpc.LatestBlockNumber.Int64()
+	}
+	return number.Int64()
+}
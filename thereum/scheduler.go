@@ -0,0 +1,157 @@
+package thereum
+
+import (
+	"errors"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Scheduled is a signed transaction held back from the txpool until the chain
+// reaches a given block number or timestamp. Timestamps are chain time, which starts
+// at 0 with the genesis block and grows by 10 seconds each block, not unix time.
+type Scheduled struct {
+	Tx          *types.Transaction
+	BlockNumber *big.Int // BlockNumber releases the tx into the block with this number
+	Timestamp   uint64   // Timestamp releases the tx into the first block with a timestamp at or after it
+}
+
+// due checks if the transaction should be released into a block with the provided
+// number and timestamp
+func (s *Scheduled) due(number *big.Int, time uint64) bool {
+	if s.BlockNumber != nil && s.BlockNumber.Cmp(number) <= 0 {
+		return true
+	}
+	return s.Timestamp != 0 && s.Timestamp <= time
+}
+
+// scheduler holds scheduled transactions until they're due
+type scheduler struct {
+	txs map[common.Hash]*Scheduled
+	mu  sync.Mutex
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{txs: make(map[common.Hash]*Scheduled)}
+}
+
+// add holds onto a scheduled transaction
+func (s *scheduler) add(sch *Scheduled) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.txs[sch.Tx.Hash()] = sch
+}
+
+// cancel removes a scheduled transaction, reporting if it was found
+func (s *scheduler) cancel(hash common.Hash) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, has := s.txs[hash]
+	delete(s.txs, hash)
+	return has
+}
+
+// due removes and returns every transaction that is due for the block with the
+// provided number and timestamp
+func (s *scheduler) due(number *big.Int, time uint64) []*Scheduled {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*Scheduled
+	for hash, sch := range s.txs {
+		if sch.due(number, time) {
+			out = append(out, sch)
+			delete(s.txs, hash)
+		}
+	}
+	sortScheduled(out)
+	return out
+}
+
+// list returns every scheduled transaction
+func (s *scheduler) list() []*Scheduled {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*Scheduled, 0, len(s.txs))
+	for _, sch := range s.txs {
+		out = append(out, sch)
+	}
+	sortScheduled(out)
+	return out
+}
+
+// sortScheduled orders scheduled txs by block number, then timestamp, then nonce
+// so that a sender's txs are released in a sensible order.
+func sortScheduled(s []*Scheduled) {
+	sort.Slice(s, func(i, j int) bool {
+		a, b := s[i], s[j]
+		switch {
+		case a.BlockNumber != nil && b.BlockNumber != nil && a.BlockNumber.Cmp(b.BlockNumber) != 0:
+			return a.BlockNumber.Cmp(b.BlockNumber) < 0
+		case a.BlockNumber != nil && b.BlockNumber == nil:
+			return true
+		case a.BlockNumber == nil && b.BlockNumber != nil:
+			return false
+		case a.Timestamp != b.Timestamp:
+			return a.Timestamp < b.Timestamp
+		}
+		return a.Tx.Nonce() < b.Tx.Nonce()
+	})
+}
+
+////////////////////////////////////
+// 	Scheduling Transactions
+//////////////////////////////////
+
+// Schedule holds a signed transaction until the chain reaches the provided block number
+// or timestamp, and then adds it to the txpool. Either condition releases the tx. The
+// timestamp is compared to block timestamps, which are chain time rather than unix
+// time. The transaction is fully validated once it is released.
+func (t *Thereum) Schedule(tx *types.Transaction, blockNumber *big.Int, timestamp uint64) error {
+	if blockNumber == nil && timestamp == 0 {
+		return errors.New("invalid schedule: a block number or timestamp is required")
+	}
+	if blockNumber != nil && blockNumber.Cmp(t.LatestBlock().Number()) <= 0 {
+		return errors.New("invalid schedule: block has already been mined")
+	}
+	// check what can be checked ahead of time
+	if uint64(tx.Size()) > txMaxSize {
+		return errors.New("invalid transaction: too large")
+	}
+	if t.blockchain.GasLimit() < tx.Gas() {
+		return errors.New("invalid transaction: gas limit broken")
+	}
+	if _, err := types.Sender(t.signer, tx); err != nil {
+		return errors.New("invalid transaction: signature could not be verified")
+	}
+	t.scheduler.add(&Scheduled{Tx: tx, BlockNumber: blockNumber, Timestamp: timestamp})
+	return nil
+}
+
+// CancelScheduled stops a scheduled transaction from being released, reporting if
+// the transaction was found
+func (t *Thereum) CancelScheduled(hash common.Hash) bool {
+	return t.scheduler.cancel(hash)
+}
+
+// Scheduled lists the transactions waiting to be released into the txpool
+func (t *Thereum) Scheduled() []*Scheduled {
+	return t.scheduler.list()
+}
+
+// releaseScheduled adds the scheduled transactions due for the next block to the txpool.
+// Transactions that fail validation once released are logged and dropped.
+func (t *Thereum) releaseScheduled() {
+	parent := t.LatestBlock()
+	number := new(big.Int).Add(parent.Number(), common.Big1)
+	time := nextBlockTime(parent)
+	for _, sch := range t.scheduler.due(number, time) {
+		err := t.AddTx(sch.Tx)
+		if err != nil {
+			log.Printf("could not release scheduled transaction %s: %s\n", sch.Tx.Hash().Hex(), err)
+		}
+	}
+}
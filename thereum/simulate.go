@@ -0,0 +1,47 @@
+package thereum
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Simulation is the result of applying transactions on top of the latest block
+// without adding them to the chain
+type Simulation struct {
+	State    *state.StateDB
+	Receipts []*types.Receipt
+	builder  *blockBuilder
+}
+
+// Call executes a read only message call against the simulated state
+func (s *Simulation) Call(from common.Address, to common.Address, data []byte) ([]byte, error) {
+	return s.builder.call(from, to, data)
+}
+
+// Simulate applies the transactions, in order, to a copy of the latest state. An
+// error is returned if any of the transactions can't be applied, while transactions
+// that fail during execution are reported in their receipts.
+func (t *Thereum) Simulate(txs ...*types.Transaction) (*Simulation, error) {
+	builder, err := newBlockBuilder(
+		t.chainConfig,
+		t.blockchain,
+		ethash.NewFaker(),
+		t.database,
+		t.blockchain.CurrentBlock(),
+		t.root.Address,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range txs {
+		_, err := builder.apply(tx)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply transaction %s: %s", tx.Hash().Hex(), err)
+		}
+	}
+	return &Simulation{State: builder.state, Receipts: builder.receipts, builder: builder}, nil
+}
@@ -19,25 +19,38 @@ import (
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/eth/filters"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/evan-forbes/ethlab/txpool"
 )
 
 // Thereum contains and controls the processes needed to run a single node
 // PoA ethereum blockchain.
 type Thereum struct {
-	ctx      context.Context
-	wg       *sync.WaitGroup
-	root     *Account
-	txPool   *txpool.LinkedPool
-	gasLimit uint64
+	ctx         context.Context
+	wg          *sync.WaitGroup
+	root        *Account
+	txPool      txpool.Pooler
+	bundles     txpool.Bundler // bundles is the txpool's support for bundles, nil if it has none
+	scheduler   *scheduler     // holds transactions until they're due to be pooled
+	adversaries *adversaries   // adversaries attacking incoming transactions
+	fuzzer      *fuzzer        // fuzzes the order of each block's transactions
+	gasLimit    uint64
 	// gasLimit GasLimiter
 	Delay      int
 	signer     types.Signer
 	database   ethdb.Database   // In memory database to store our testing data
 	blockchain *core.BlockChain // Ethereum blockchain to handle the consensus
+	bloom      *bloomService    // indexes logs to speed up filtering
+	txFeed     *txFeed          // publishes core.NewTxsEvent for each pooled transaction
+	filters    *pollFilters     // polling filters installed over rpc
+	nonces     *nonceTracker    // nonces used by the unlocked accounts
 
-	mu sync.Mutex
+	filterBackend *filterBackend // serves the chain to log filters
+
+	mu    sync.Mutex
+	steps sync.RWMutex // steps is held while preparing or adding blocks, and read held by Hold
 
 	// use the locked wrapper methods to access these!
 	// I hate global state, I also don't appreciate how they're returned from the ethereum data structure, blockchain
@@ -65,24 +78,57 @@ func New(config Config, root *Account) (*Thereum, error) {
 	genBlock := genesis.MustCommit(db)
 
 	if root == nil {
-		root, _ = NewAccount("defaultRoot", big.NewInt(100))
+		root, _ = config.NewAccount("defaultRoot", big.NewInt(100))
+	}
+	// use the configured pool, or build a LinkedPool from the config
+	pool := config.Pool
+	if pool == nil {
+		pool, err = txpool.NewLinkedPoolWithConfig(config.TxPool)
+		if err != nil {
+			return nil, err
+		}
 	}
+
 	chainConfig := params.AllEthashProtocolChanges
 	chainConfig.ChainID = big.NewInt(1)
 	bc, _ := core.NewBlockChain(db, nil, chainConfig, ethash.NewFaker(), vm.Config{}, nil)
+	section := config.BloomSection
+	if section == 0 {
+		section = params.BloomBitsBlocks
+	}
+	bloom := newBloomService(db, bc, section)
+	txFeed := newTxFeed()
+	backend := &filterBackend{db: db, bc: bc, bloom: bloom, txFeed: txFeed}
+	events := filters.NewEventSystem(backend, false)
 	t := &Thereum{
-		txPool:     txpool.NewLinkedPool(),
-		database:   db,
-		blockchain: bc,
-		signer:     types.NewEIP155Signer(big.NewInt(1)),
-		root:       root,
-		gasLimit:   config.GasLimit, // TODO: config and make more flexible
-		Delay:      int(config.Delay),
-		Events:     filters.NewEventSystem(&filterBackend{db: db, bc: bc}, false),
-		Accounts:   accounts,
+		txPool:        pool,
+		scheduler:     newScheduler(),
+		adversaries:   newAdversaries(),
+		fuzzer:        &fuzzer{},
+		nonces:        newNonceTracker(),
+		database:      db,
+		blockchain:    bc,
+		signer:        types.NewEIP155Signer(big.NewInt(1)),
+		root:          root,
+		gasLimit:      config.GasLimit, // TODO: config and make more flexible
+		Delay:         int(config.Delay),
+		bloom:         bloom,
+		txFeed:        txFeed,
+		Events:        events,
+		filters:       newPollFilters(time.Duration(config.FilterTimeout) * time.Second),
+		filterBackend: backend,
+		Accounts:      accounts,
 	}
 	t.pendingBlock = genBlock
 	t.chainConfig = chainConfig
+	t.bundles, _ = pool.(txpool.Bundler)
+	// let the pool know where each new sender's nonces should start
+	if setter, ok := pool.(txpool.NonceSetter); ok {
+		setter.SetNonceFunc(func(addr common.Address) uint64 {
+			nonce, _ := t.GetNonce(addr)
+			return nonce
+		})
+	}
 	return t, nil
 }
 
@@ -108,15 +154,62 @@ func (t *Thereum) Run(ctx context.Context, wg *sync.WaitGroup) {
 func (t *Thereum) Commit() {
 	// TODO: 1)this is fugly 2) add custom delay 3) add ability to pause
 	// create a new block using existing transaction in the pool
+	block := t.Prepare()
+	if block == nil {
+		return
+	}
+	// add optional delay before adding block to simulate pending state
+	time.Sleep(time.Millisecond * time.Duration(t.Delay))
+	t.appendBlock(block)
+
+}
+
+// Prepare builds the next block using transactions from the txpool and makes it the
+// pending block, without adding it to the chain. If the pending block hasn't been
+// added yet, it's returned instead. Used with AppendPending to step through the chain
+// manually.
+func (t *Thereum) Prepare() *types.Block {
+	t.steps.Lock()
+	defer t.steps.Unlock()
+	t.mu.Lock()
+	pending := t.pendingBlock
+	if pending != nil && pending.NumberU64() > t.blockchain.CurrentBlock().NumberU64() {
+		t.mu.Unlock()
+		return pending
+	}
+	t.mu.Unlock()
+	t.releaseScheduled()
 	block, state := t.nextBlock()
+	if block == nil {
+		return nil
+	}
 	t.mu.Lock()
 	t.pendingBlock = block
 	t.pendingState = state
 	t.mu.Unlock()
-	// add optional delay before adding block to simulate pending state
-	time.Sleep(time.Millisecond * time.Duration(t.Delay))
-	t.appendBlock(block)
+	return block
+}
 
+// Hold runs f while the chain is held still, so no block is prepared or added until
+// f returns. f must not prepare or add blocks itself.
+func (t *Thereum) Hold(f func()) {
+	t.steps.RLock()
+	defer t.steps.RUnlock()
+	f()
+}
+
+// AppendPending adds the pending block to the chain, reporting false if there isn't
+// a pending block that hasn't been added
+func (t *Thereum) AppendPending() bool {
+	t.mu.Lock()
+	block := t.pendingBlock
+	appended := block == nil || block.NumberU64() <= t.blockchain.CurrentBlock().NumberU64()
+	t.mu.Unlock()
+	if appended {
+		return false
+	}
+	t.appendBlock(block)
+	return true
 }
 
 // nextBlock mints a new block, filling it with transactions from the transaction pool
@@ -124,32 +217,111 @@ func (t *Thereum) nextBlock() (*types.Block, *state.StateDB) {
 	// make new blocks using the transaction pool
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	blocks, _ := core.GenerateChain(
+	parent := t.blockchain.CurrentBlock()
+	builder, err := newBlockBuilder(
 		t.chainConfig,
-		t.blockchain.CurrentBlock(),
+		t.blockchain,
 		ethash.NewFaker(),
 		t.database,
-		1,
-		func(i int, b *core.BlockGen) {
-			b.SetCoinbase(t.root.Address)
-			// get the next set of highest paying transactions
-			txs := t.txPool.Batch(t.gasLimit)
-			// add them to the new block.
-			for _, tx := range txs {
-				b.AddTx(tx)
-				fmt.Println("finalized: ", tx.Hash().Hex())
-			}
-		},
+		parent,
+		t.root.Address,
 	)
-	statedb, _ := t.blockchain.State()
+	if err != nil {
+		log.Println("could not start a new block:", err)
+		return nil, nil
+	}
+	// bundles go at the top of the block
+	t.applyBundles(builder)
 
-	freshBlock := blocks[0]
+	// get the next set of highest paying transactions
+	limit := builder.GasLeft()
+	if used := builder.header.GasUsed; used < t.gasLimit && t.gasLimit-used < limit {
+		limit = t.gasLimit - used
+	}
+	txs := t.txPool.Batch(limit)
+	// fuzzing works on copies of the block, so reads don't have to wait for it
+	t.mu.Unlock()
+	err = t.fuzzBlock(builder, txs)
+	t.mu.Lock()
+	if err != nil {
+		log.Println("could not fuzz block:", err)
+	}
+	// add them to the new block. Once one of a sender's transactions fails, the
+	// rest of theirs would fail on the nonce gap, so they go back to the pool.
+	failed := make(map[common.Address]bool)
+	var held []*types.Transaction
+	for _, tx := range txs {
+		from, err := types.Sender(t.signer, tx)
+		if err == nil && failed[from] {
+			held = append(held, tx)
+			continue
+		}
+		_, err = builder.apply(tx)
+		if err != nil {
+			failed[from] = true
+			t.discard([]*types.Transaction{tx}, err)
+		}
+	}
+	t.txPool.Restore(held)
+	freshBlock, err := builder.finalize()
+	if err != nil {
+		log.Println("could not finalize block:", err)
+		return nil, nil
+	}
+	statedb, _ := t.blockchain.State()
 
 	freshState, _ := state.New(freshBlock.Root(), statedb.Database())
 	return freshBlock, freshState
 }
 
+// applyBundles places the bundles targeting the builder's block into the block
+func (t *Thereum) applyBundles(builder *blockBuilder) {
+	if t.bundles == nil {
+		return
+	}
+	var waiting []*txpool.Bundle
+	defer func() { t.bundles.Requeue(waiting) }()
+	for _, bundle := range t.bundles.Bundles(builder.Number()) {
+		atk, isAttack := t.adversaries.lookup(bundle.Hash())
+		if bundle.Gas() > builder.GasLeft() {
+			// bundles without a target can wait for the next block
+			if bundle.BlockNumber == nil {
+				waiting = append(waiting, bundle)
+				continue
+			}
+			if isAttack {
+				t.failAttack(atk, core.ErrGasLimitReached)
+				continue
+			}
+			t.discard(bundle.Transactions, core.ErrGasLimitReached)
+			continue
+		}
+		var err error
+		if isAttack {
+			err = t.applyAttack(builder, atk)
+		} else {
+			err = builder.applyBundle(bundle)
+			if err != nil {
+				t.discard(bundle.Transactions, err)
+			}
+		}
+		if err != nil {
+			continue
+		}
+		// the bundle used up nonces that the pool might be holding
+		for _, tx := range bundle.Transactions {
+			from, err := types.Sender(t.signer, tx)
+			if err != nil {
+				continue
+			}
+			t.bundles.Advance(from, builder.state.GetNonce(from))
+		}
+	}
+}
+
 func (t *Thereum) appendBlock(block *types.Block) {
+	t.steps.Lock()
+	defer t.steps.Unlock()
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	_, err := t.blockchain.InsertChain([]*types.Block{block})
@@ -171,11 +343,79 @@ func (t *Thereum) AddTx(tx *types.Transaction) error {
 	if err != nil {
 		return fmt.Errorf("could not validate transaction: %s", err)
 	}
-	t.txPool.Insert(from, tx)
-	fmt.Println("pooled    ", tx.Hash().Hex())
+	err = t.txPool.Insert(from, tx)
+	if err != nil {
+		return err
+	}
+	// adversaries get the chance to bundle their own transactions around this one
+	t.attack(from, tx)
+	t.txFeed.Send(core.NewTxsEvent{Txs: []*types.Transaction{tx}})
 	return nil
 }
 
+// SubscribeNewTxsEvent registers a subscription for transactions added with AddTx
+// without blocking it, so events are dropped for channels that aren't ready
+func (t *Thereum) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription {
+	return t.txFeed.Subscribe(ch)
+}
+
+// AddBundle validates and queues a bundle of transactions that will be included in
+// the same block, one after another, or not at all. The bundle's hash is returned.
+func (t *Thereum) AddBundle(bundle *txpool.Bundle) (common.Hash, error) {
+	if bundle.BlockNumber != nil && bundle.BlockNumber.Cmp(t.LatestBlock().Number()) <= 0 {
+		return common.Hash{}, errors.New("invalid bundle: target block has passed")
+	}
+	if t.blockchain.GasLimit() < bundle.Gas() {
+		return common.Hash{}, errors.New("invalid bundle: gas limit broken")
+	}
+	for _, tx := range bundle.Transactions {
+		_, err := t.validateTx(tx)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("could not validate bundled transaction %s: %s", tx.Hash().Hex(), err)
+		}
+	}
+	if t.bundles == nil {
+		return common.Hash{}, errNoBundles
+	}
+	err := t.bundles.AddBundle(bundle)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return bundle.Hash(), nil
+}
+
+// errNoBundles is returned when adding a bundle to a txpool that isn't a Bundler
+var errNoBundles = errors.New("the txpool does not support bundles")
+
+// Dropped reports if, and why, a transaction recently left the txpool without being
+// added to a block, such as being replaced by a transaction paying a higher gas price.
+// Nothing is reported if the txpool isn't a Dropper.
+func (t *Thereum) Dropped(hash common.Hash) (txpool.Drop, bool) {
+	dropper, ok := t.txPool.(txpool.Dropper)
+	if !ok {
+		return txpool.Drop{}, false
+	}
+	return dropper.Dropped(hash)
+}
+
+// discard lets the txpool know that the transactions pulled from it couldn't be
+// executed, if it keeps track
+func (t *Thereum) discard(txs []*types.Transaction, reason error) {
+	if dropper, ok := t.txPool.(txpool.Dropper); ok {
+		dropper.Discard(txs, reason)
+	}
+}
+
+// TxPoolContent returns a consistent copy of the transactions waiting in the txpool,
+// which is empty if the txpool isn't a Snapshotter
+func (t *Thereum) TxPoolContent() *txpool.Snapshot {
+	snapshotter, ok := t.txPool.(txpool.Snapshotter)
+	if !ok {
+		return &txpool.Snapshot{}
+	}
+	return snapshotter.Snapshot()
+}
+
 // validateTx checks whether a transaction is valid according to the consensus
 // rules and adheres to some heuristic limits (price and size).
 func (t *Thereum) validateTx(tx *types.Transaction) (common.Address, error) {
@@ -246,6 +486,8 @@ func (t *Thereum) LatestBlock() *types.Block {
 // Shutdown begins the procedure to stop the Thereum blockchain
 func (t *Thereum) Shutdown(wg *sync.WaitGroup) {
 	defer wg.Done()
+	t.filters.close()
+	t.bloom.close()
 	t.blockchain.Stop()
 }
 
@@ -269,7 +511,7 @@ func (t *Thereum) TransactionCount(ctx context.Context, blockHash common.Hash) (
 
 // TransactionCountByAddress returns the number of transactions sent by an address at a given block
 func (t *Thereum) TransactionCountByAddress(ctx context.Context, addr common.Address, blockHash common.Hash) (*hexutil.Uint64, error) {
-	state, err := t.blockchain.StateAt(blockHash)
+	state, err := t.StateAtBlock(ctx, rpc.BlockNumberOrHashWithHash(blockHash, false))
 	if err != nil {
 		return nil, err
 	}
@@ -279,36 +521,105 @@ func (t *Thereum) TransactionCountByAddress(ctx context.Context, addr common.Add
 
 // GetNonce retrieves the lowest excepted nonce of an address
 func (t *Thereum) GetNonce(addr common.Address) (uint64, error) {
-	state, err := t.blockchain.StateAt(t.LatestBlock().Hash())
+	state, err := t.blockchain.StateAt(t.LatestBlock().Root())
 	if err != nil {
 		return 0, err
 	}
 	return state.GetNonce(addr), nil
 }
 
-// stateByBlockNumber retrieves a state by a given blocknumber.
+// PendingNonce returns the next nonce of an address, counting the transactions it
+// has in the pending block and those still waiting in the txpool
+func (t *Thereum) PendingNonce(addr common.Address) (uint64, error) {
+	nonce, err := t.pendingNonce(addr)
+	if err != nil {
+		return 0, err
+	}
+	for n := range t.TxPoolContent().Pending[addr] {
+		if n >= nonce {
+			nonce = n + 1
+		}
+	}
+	return nonce, nil
+}
+
+// pendingNonce reads the nonce of an address from the pending state, or the latest
+// state if there isn't a pending block
+func (t *Thereum) pendingNonce(addr common.Address) (uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pendingState == nil {
+		return t.GetNonce(addr)
+	}
+	return t.pendingState.GetNonce(addr), nil
+}
+
+// stateByBlockNumber retrieves a state by a given blocknumber. Callers must not hold
+// t.mu, which is taken to read the pending state.
 func (t *Thereum) stateByBlockNumber(ctx context.Context, blockNumber *big.Int) (*state.StateDB, error) {
-	if blockNumber == nil || blockNumber.Cmp(t.blockchain.CurrentBlock().Number()) == 0 {
+	if blockNumber == nil {
 		return t.blockchain.State()
 	}
-	block, err := t.BlockByNumber(ctx, blockNumber)
+	return t.StateAtBlock(ctx, rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(blockNumber.Int64())))
+}
+
+// StateAtBlock retrieves the state at a block number, tag, or hash. Errors describe
+// whether the block is unknown or its state is no longer available.
+func (t *Thereum) StateAtBlock(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*state.StateDB, error) {
+	var block *types.Block
+	if number, ok := blockNrOrHash.Number(); ok {
+		switch number {
+		case rpc.LatestBlockNumber:
+			block = t.blockchain.CurrentBlock()
+		case rpc.PendingBlockNumber:
+			return t.PendingState()
+		default:
+			block = t.blockchain.GetBlockByNumber(uint64(number.Int64()))
+			if block == nil {
+				return nil, fmt.Errorf("block #%d not found", number.Int64())
+			}
+		}
+	}
+	if hash, ok := blockNrOrHash.Hash(); ok {
+		block = t.blockchain.GetBlockByHash(hash)
+		if block == nil {
+			return nil, fmt.Errorf("block %s not found", hash.Hex())
+		}
+		if blockNrOrHash.RequireCanonical && t.blockchain.GetCanonicalHash(block.NumberU64()) != hash {
+			return nil, fmt.Errorf("block %s is not canonical", hash.Hex())
+		}
+	}
+	if block == nil {
+		return nil, errors.New("invalid block number or hash")
+	}
+	statedb, err := t.blockchain.StateAt(block.Root())
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("state for block #%d is not available, it may have been pruned: %s", block.NumberU64(), err)
 	}
-	return t.blockchain.StateAt(block.Hash())
+	return statedb, nil
 }
 
-// BlockByNumber retrieves a block from the database by number, caching it
-// (associated with its hash) if found.
-func (t *Thereum) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+// PendingState returns a copy of the state of the block about to be added to the
+// chain, or the latest state if there isn't one
+func (t *Thereum) PendingState() (*state.StateDB, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-
-	if number == nil || number.Cmp(t.LatestBlock().Number()) == 0 {
-		return t.blockchain.CurrentBlock(), nil
+	if t.pendingState == nil {
+		return t.blockchain.State()
 	}
+	return t.pendingState.Copy(), nil
+}
 
-	block := t.blockchain.GetBlockByNumber(uint64(number.Int64()))
+// BlockByNumber retrieves a block from the database by number, caching it
+// (associated with its hash) if found.
+func (t *Thereum) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	if number == nil {
+		return t.LatestBlock(), nil
+	}
+	if number.Sign() < 0 {
+		return t.BlockByTag(ctx, rpc.BlockNumber(number.Int64()))
+	}
+	block := t.blockchain.GetBlockByNumber(number.Uint64())
 	if block == nil {
 		return nil, errors.New("block does not exist")
 	}
@@ -316,11 +627,83 @@ func (t *Thereum) BlockByNumber(ctx context.Context, number *big.Int) (*types.Bl
 	return block, nil
 }
 
-// BalanceAt returns the wei balance of a certain account in the blockchain.
-func (t *Thereum) BalanceAt(ctx context.Context, contract common.Address, blockNumber *big.Int) (*big.Int, error) {
+// BlockByHash retrieves a block from the database by hash
+func (t *Thereum) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	block := t.blockchain.GetBlockByHash(hash)
+	if block == nil {
+		return nil, errors.New("block does not exist")
+	}
+	return block, nil
+}
+
+// BlockByTag retrieves a block using an rpc block number, which can also be one of
+// the "latest", "earliest", or "pending" tags
+func (t *Thereum) BlockByTag(ctx context.Context, number rpc.BlockNumber) (*types.Block, error) {
+	switch number {
+	case rpc.LatestBlockNumber:
+		return t.LatestBlock(), nil
+	case rpc.PendingBlockNumber:
+		return t.PendingBlock(), nil
+	}
+	if number < 0 {
+		return nil, fmt.Errorf("invalid block number %d", number)
+	}
+	return t.BlockByNumber(ctx, big.NewInt(number.Int64()))
+}
+
+// PendingBlock returns the block that is about to be added to the chain, or the
+// latest block if there isn't one
+func (t *Thereum) PendingBlock() *types.Block {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	if t.pendingBlock == nil {
+		return t.blockchain.CurrentBlock()
+	}
+	return t.pendingBlock
+}
+
+// TotalDifficulty returns the total difficulty of the chain up to and including the
+// block with the provided hash
+func (t *Thereum) TotalDifficulty(hash common.Hash) *big.Int {
+	return t.blockchain.GetTdByHash(hash)
+}
 
+// TransactionByHash finds a transaction, along with the hash and number of the block
+// it was included in and its index in that block. Transactions waiting in the txpool
+// are returned with an empty block hash.
+func (t *Thereum) TransactionByHash(hash common.Hash) (tx *types.Transaction, blockHash common.Hash, blockNumber uint64, index uint64, err error) {
+	tx, blockHash, blockNumber, index = rawdb.ReadTransaction(t.database, hash)
+	if tx != nil {
+		return tx, blockHash, blockNumber, index, nil
+	}
+	ptx, _, has := t.TxPoolContent().Lookup(hash)
+	if !has {
+		return nil, common.Hash{}, 0, 0, errors.New("transaction does not exist")
+	}
+	return ptx.Tx, common.Hash{}, 0, 0, nil
+}
+
+// CodeAt returns the code deployed at an address
+func (t *Thereum) CodeAt(ctx context.Context, addr common.Address, blockNumber *big.Int) ([]byte, error) {
+	statedb, err := t.stateByBlockNumber(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return statedb.GetCode(addr), nil
+}
+
+// StorageAt returns the value stored under a key in an address's storage
+func (t *Thereum) StorageAt(ctx context.Context, addr common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	statedb, err := t.stateByBlockNumber(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	val := statedb.GetState(addr, key)
+	return val[:], nil
+}
+
+// BalanceAt returns the wei balance of a certain account in the blockchain.
+func (t *Thereum) BalanceAt(ctx context.Context, contract common.Address, blockNumber *big.Int) (*big.Int, error) {
 	statedb, err := t.stateByBlockNumber(ctx, blockNumber)
 	if err != nil {
 		return nil, err
@@ -2,14 +2,22 @@ package thereum
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/filters"
 	"github.com/evan-forbes/ethlab/cmd"
+	"github.com/evan-forbes/ethlab/txpool"
+	"github.com/matryer/is"
 )
 
 func setupThereum(t *testing.T) (*Thereum, *cmd.Manager) {
@@ -115,3 +123,504 @@ func TestCreateSend(t *testing.T) {
 	fmt.Println(string(j))
 
 }
+
+// runThereum starts a chain with funded alice and bob accounts, returning a func to
+// stop it
+func runThereum(t *testing.T) (*Thereum, func()) {
+	config := DefaultConfig()
+	config.Allocation["alice"] = "1000000000000000000000"
+	config.Allocation["bob"] = "1000000000000000000000"
+	eth, err := New(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go eth.Run(ctx, wg)
+	return eth, func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// waitForReceipt polls for the receipt of a transaction until the timeout
+func waitForReceipt(eth *Thereum, hash common.Hash, timeout time.Duration) *types.Receipt {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		receipt, _ := eth.TxReceipt(hash)
+		if receipt != nil {
+			return receipt
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func TestAddTx(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runThereum(t)
+	defer stop()
+	alice, bob := eth.Accounts["alice"], eth.Accounts["bob"]
+
+	tx, err := alice.CreateSend(bob.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(tx))
+	receipt := waitForReceipt(eth, tx.Hash(), 5*time.Second)
+	is.True(receipt != nil)
+	is.Equal(receipt.Status, types.ReceiptStatusSuccessful)
+}
+
+func TestSlowTxSubscriber(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runThereum(t)
+	defer stop()
+	alice, bob := eth.Accounts["alice"], eth.Accounts["bob"]
+
+	// a subscriber that never reads must not hold up the txpool
+	stalled := make(chan core.NewTxsEvent)
+	sub := eth.SubscribeNewTxsEvent(stalled)
+	defer sub.Unsubscribe()
+	ready := make(chan core.NewTxsEvent, 1)
+	readySub := eth.SubscribeNewTxsEvent(ready)
+	defer readySub.Unsubscribe()
+
+	added := make(chan error, 1)
+	go func() {
+		for i := 0; i < 3; i++ {
+			tx, err := alice.CreateSend(bob.Address, big.NewInt(1))
+			if err == nil {
+				err = eth.AddTx(tx)
+			}
+			if err != nil {
+				added <- err
+				return
+			}
+		}
+		added <- nil
+	}()
+	select {
+	case err := <-added:
+		is.NoErr(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("AddTx blocked on a stalled subscriber")
+	}
+	// subscribers that are ready still receive, while later events are dropped
+	ev := <-ready
+	is.Equal(len(ev.Txs), 1)
+}
+
+func TestPendingNonce(t *testing.T) {
+	is := is.New(t)
+	config := DefaultConfig()
+	config.Allocation["alice"] = "1000000000000000000000"
+	eth, err := New(config, nil)
+	is.NoErr(err)
+	alice := eth.Accounts["alice"]
+	start, err := eth.GetNonce(alice.Address)
+	is.NoErr(err)
+
+	// txs in the pending block count, as well as those left in the txpool
+	tx, err := alice.CreateSend(alice.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(tx))
+	is.True(eth.Prepare() != nil)
+	is.Equal(len(eth.TxPoolContent().Pending[alice.Address]), 0)
+	nonce, err := eth.PendingNonce(alice.Address)
+	is.NoErr(err)
+	is.Equal(nonce, start+1)
+	tx, err = alice.CreateSend(alice.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(tx))
+	nonce, err = eth.PendingNonce(alice.Address)
+	is.NoErr(err)
+	is.Equal(nonce, start+2)
+}
+
+func TestPendingStateLookups(t *testing.T) {
+	is := is.New(t)
+	config := DefaultConfig()
+	config.Allocation["alice"] = "1000000000000000000000"
+	eth, err := New(config, nil)
+	is.NoErr(err)
+	alice := eth.Accounts["alice"]
+	to := common.HexToAddress("0x0d")
+	tx, err := alice.CreateSend(to, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(tx))
+	is.True(eth.Prepare() != nil)
+
+	// pending lookups read the pending state, and must not deadlock doing so
+	done := make(chan *big.Int)
+	go func() {
+		bal, err := eth.BalanceAt(context.Background(), to, big.NewInt(-2))
+		is.NoErr(err)
+		_, err = eth.CodeAt(context.Background(), to, big.NewInt(-2))
+		is.NoErr(err)
+		_, err = eth.StorageAt(context.Background(), to, common.Hash{}, big.NewInt(-2))
+		is.NoErr(err)
+		done <- bal
+	}()
+	select {
+	case bal := <-done:
+		is.Equal(bal.Int64(), int64(1))
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending state lookup deadlocked")
+	}
+	bal, err := eth.BalanceAt(context.Background(), to, big.NewInt(-1))
+	is.NoErr(err)
+	is.Equal(bal.Int64(), int64(0))
+
+	// negative block numbers are tags rather than heights
+	block, err := eth.BlockByNumber(context.Background(), big.NewInt(-2))
+	is.NoErr(err)
+	is.Equal(block.Hash(), eth.PendingBlock().Hash())
+	block, err = eth.BlockByNumber(context.Background(), big.NewInt(-1))
+	is.NoErr(err)
+	is.Equal(block.Hash(), eth.LatestBlock().Hash())
+	_, err = eth.BlockByNumber(context.Background(), big.NewInt(-3))
+	is.True(err != nil)
+}
+
+// minimalPool only has the methods every Pooler needs
+type minimalPool struct {
+	pool *txpool.LinkedPool
+}
+
+func (p *minimalPool) Insert(author common.Address, txs ...*types.Transaction) error {
+	return p.pool.Insert(author, txs...)
+}
+
+func (p *minimalPool) Batch(gasLimit uint64) []*types.Transaction {
+	return p.pool.Batch(gasLimit)
+}
+
+func (p *minimalPool) Restore(txs []*types.Transaction) {
+	p.pool.Restore(txs)
+}
+
+func TestMinimalPooler(t *testing.T) {
+	is := is.New(t)
+	config := DefaultConfig()
+	config.Allocation["alice"] = "1000000000000000000000"
+	config.Pool = &minimalPool{pool: txpool.NewLinkedPool()}
+	eth, err := New(config, nil)
+	is.NoErr(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go eth.Run(ctx, wg)
+	defer wg.Wait()
+	defer cancel()
+	alice := eth.Accounts["alice"]
+
+	tx, err := alice.CreateSend(alice.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(tx))
+	receipt := waitForReceipt(eth, tx.Hash(), 5*time.Second)
+	is.True(receipt != nil)
+
+	// features the pool doesn't support are left out
+	bundled, err := alice.CreateSend(alice.Address, big.NewInt(1))
+	is.NoErr(err)
+	_, err = eth.AddBundle(&txpool.Bundle{Transactions: []*types.Transaction{bundled}})
+	is.Equal(err, errNoBundles)
+	is.Equal(len(eth.TxPoolContent().Pending), 0)
+	_, dropped := eth.Dropped(tx.Hash())
+	is.True(!dropped)
+}
+
+func TestAddBundle(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runThereum(t)
+	defer stop()
+	alice, bob := eth.Accounts["alice"], eth.Accounts["bob"]
+
+	first, err := alice.CreateSend(bob.Address, big.NewInt(1))
+	is.NoErr(err)
+	second, err := alice.CreateSend(bob.Address, big.NewInt(2))
+	is.NoErr(err)
+	_, err = eth.AddBundle(&txpool.Bundle{
+		Transactions: []*types.Transaction{first, second},
+		RevertOnFail: true,
+	})
+	is.NoErr(err)
+
+	r1 := waitForReceipt(eth, first.Hash(), 5*time.Second)
+	r2 := waitForReceipt(eth, second.Hash(), 5*time.Second)
+	is.True(r1 != nil)
+	is.True(r2 != nil)
+	is.Equal(r1.BlockNumber, r2.BlockNumber)
+	is.Equal(r1.TransactionIndex+1, r2.TransactionIndex)
+}
+
+func TestRevertBundle(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runThereum(t)
+	defer stop()
+	alice, bob := eth.Accounts["alice"], eth.Accounts["bob"]
+
+	send, err := alice.CreateSend(bob.Address, big.NewInt(1))
+	is.NoErr(err)
+	// deploying code that hits an invalid opcode always fails
+	fail, err := alice.Sign(types.NewContractCreation(alice.Nonce.Uint64(), big.NewInt(0), 100000, alice.TxOpts.GasPrice, []byte{0xfe}))
+	is.NoErr(err)
+	_, err = eth.AddBundle(&txpool.Bundle{
+		Transactions: []*types.Transaction{send, fail},
+		RevertOnFail: true,
+	})
+	is.NoErr(err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	var drop txpool.Drop
+	var dropped bool
+	for !dropped && time.Now().Before(deadline) {
+		drop, dropped = eth.Dropped(send.Hash())
+		time.Sleep(10 * time.Millisecond)
+	}
+	is.True(dropped)
+	is.Equal(drop.Reason, txpool.ErrBundleReverted)
+	receipt, _ := eth.TxReceipt(send.Hash())
+	is.True(receipt == nil)
+}
+
+func TestSchedule(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runThereum(t)
+	defer stop()
+	alice, bob := eth.Accounts["alice"], eth.Accounts["bob"]
+
+	target := new(big.Int).Add(eth.LatestBlock().Number(), big.NewInt(10))
+	tx, err := alice.CreateSend(bob.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(eth.Schedule(tx, target, 0))
+
+	// a canceled tx is never released
+	canceled, err := bob.CreateSend(alice.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(eth.Schedule(canceled, target, 0))
+	is.Equal(len(eth.Scheduled()), 2)
+	is.True(eth.CancelScheduled(canceled.Hash()))
+	is.Equal(len(eth.Scheduled()), 1)
+
+	receipt := waitForReceipt(eth, tx.Hash(), 5*time.Second)
+	is.True(receipt != nil)
+	is.Equal(receipt.BlockNumber, target)
+	is.Equal(len(eth.Scheduled()), 0)
+	receipt, _ = eth.TxReceipt(canceled.Hash())
+	is.True(receipt == nil)
+}
+
+func TestScheduleTimestamp(t *testing.T) {
+	is := is.New(t)
+	eth, stop := runThereum(t)
+	defer stop()
+	alice, bob := eth.Accounts["alice"], eth.Accounts["bob"]
+
+	// timestamps are chain time, so a few blocks ahead of the latest block
+	target := eth.LatestBlock().Time() + 3*blockTime
+	tx, err := alice.CreateSend(bob.Address, big.NewInt(1))
+	is.NoErr(err)
+	is.NoErr(eth.Schedule(tx, nil, target))
+
+	receipt := waitForReceipt(eth, tx.Hash(), 5*time.Second)
+	is.True(receipt != nil)
+	block, err := eth.BlockByNumber(context.Background(), receipt.BlockNumber)
+	is.NoErr(err)
+	is.True(block.Time() >= target)
+	is.True(block.Time() < target+blockTime)
+}
+
+func TestOrderBySeed(t *testing.T) {
+	is := is.New(t)
+	alice, _ := NewAccount("alice", big.NewInt(0))
+	bob, _ := NewAccount("bob", big.NewInt(0))
+	var txs []*types.Transaction
+	for i := 0; i < 5; i++ {
+		tx, err := alice.CreateSend(bob.Address, big.NewInt(1))
+		is.NoErr(err)
+		txs = append(txs, tx)
+		tx, err = bob.CreateSend(alice.Address, big.NewInt(1))
+		is.NoErr(err)
+		txs = append(txs, tx)
+	}
+	for seed := int64(0); seed < 10; seed++ {
+		ordered := OrderBySeed(txs, seed)
+		is.Equal(len(ordered), len(txs))
+		// each sender's nonces stay in order
+		next := map[common.Address]uint64{}
+		for _, tx := range ordered {
+			from, err := types.Sender(types.NewEIP155Signer(big.NewInt(1)), tx)
+			is.NoErr(err)
+			is.Equal(tx.Nonce(), next[from])
+			next[from]++
+		}
+	}
+	is.Equal(OrderBySeed(txs, 7), OrderBySeed(txs, 7))
+}
+
+func TestFuzzOrderings(t *testing.T) {
+	is := is.New(t)
+	config := DefaultConfig()
+	config.Allocation["alice"] = "1000000000000000000000"
+	config.Allocation["bob"] = "1000000000000000000000"
+	config.Allocation["carol"] = "1000000000000000000000"
+	eth, err := New(config, nil)
+	is.NoErr(err)
+	alice, bob, carol := eth.Accounts["alice"], eth.Accounts["bob"], eth.Accounts["carol"]
+	sink, _ := NewAccount("sink", big.NewInt(0))
+
+	var txs []*types.Transaction
+	for _, acc := range []*Account{alice, bob, carol} {
+		tx, err := acc.CreateSend(sink.Address, big.NewInt(1))
+		is.NoErr(err)
+		txs = append(txs, tx)
+	}
+	aliceTx, carolTx := txs[0].Hash(), txs[2].Hash()
+	// carol must never go before alice
+	carolFirst := func(state *state.StateDB, receipts []*types.Receipt) error {
+		var carolWent bool
+		for _, receipt := range receipts {
+			switch receipt.TxHash {
+			case aliceTx:
+				if carolWent {
+					return errors.New("carol went first")
+				}
+			case carolTx:
+				carolWent = true
+			}
+		}
+		return nil
+	}
+	passing := func(state *state.StateDB, receipts []*types.Receipt) error {
+		if state.GetBalance(sink.Address).Sign() == 0 {
+			return errors.New("sink was never paid")
+		}
+		return nil
+	}
+
+	failure, err := eth.FuzzOrderings(eth.LatestBlock(), txs, FuzzConfig{Runs: 50, Invariants: []Invariant{passing, carolFirst}})
+	is.NoErr(err)
+	is.True(failure != nil)
+	is.Equal(failure.Invariant, 1)
+	// bob's transaction has nothing to do with it
+	is.Equal(len(failure.Ordering), 2)
+	is.Equal(failure.Ordering[0].Hash(), carolTx)
+	is.Equal(failure.Ordering[1].Hash(), aliceTx)
+
+	// the seed reproduces the failure
+	base, err := eth.newBuilder(eth.LatestBlock())
+	is.NoErr(err)
+	_, broken := runOrdering(base, OrderBySeed(txs, failure.Seed), []Invariant{passing, carolFirst})
+	is.True(broken != nil)
+
+	failure, err = eth.FuzzOrderings(eth.LatestBlock(), txs, FuzzConfig{Runs: 50, Invariants: []Invariant{passing}})
+	is.NoErr(err)
+	is.True(failure == nil)
+}
+
+func TestBloomIndex(t *testing.T) {
+	is := is.New(t)
+	config := DefaultConfig()
+	config.Allocation["alice"] = "1000000000000000000000"
+	config.BloomSection = 8
+	config.Delay = 0
+	eth, err := New(config, nil)
+	is.NoErr(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go eth.Run(ctx, wg)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	alice := eth.Accounts["alice"]
+
+	// the constructor emits a single empty log
+	deploy, err := alice.Sign(types.NewContractCreation(alice.Nonce.Uint64(), big.NewInt(0), 100000, alice.TxOpts.GasPrice, common.FromHex("0x60006000a000")))
+	is.NoErr(err)
+	is.NoErr(eth.AddTx(deploy))
+	receipt := waitForReceipt(eth, deploy.Hash(), 5*time.Second)
+	is.True(receipt != nil)
+	is.Equal(len(receipt.Logs), 1)
+
+	// wait for the block with the log to be indexed
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_, sections := eth.bloom.status()
+		if sections*8 > receipt.BlockNumber.Uint64()+8 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, sections := eth.bloom.status()
+	is.True(sections*8 > receipt.BlockNumber.Uint64()+8)
+
+	backend := &filterBackend{db: eth.database, bc: eth.blockchain, bloom: eth.bloom}
+	filter := filters.NewRangeFilter(backend, 0, int64(sections*8-1), []common.Address{receipt.ContractAddress}, nil)
+	logs, err := filter.Logs(context.Background())
+	is.NoErr(err)
+	is.Equal(len(logs), 1)
+	is.Equal(logs[0].TxHash, deploy.Hash())
+}
+
+func TestFilterTimeout(t *testing.T) {
+	is := is.New(t)
+	config := DefaultConfig()
+	config.FilterTimeout = 1
+	eth, err := New(config, nil)
+	is.NoErr(err)
+	defer eth.filters.close()
+
+	polled := eth.NewBlockFilter()
+	idle := eth.NewPendingTxFilter()
+	for i := 0; i < 4; i++ {
+		time.Sleep(400 * time.Millisecond)
+		_, err = eth.FilterChanges(polled)
+		is.NoErr(err)
+	}
+	_, err = eth.FilterChanges(idle)
+	is.Equal(err, ErrFilterNotFound)
+	is.True(eth.UninstallFilter(polled))
+}
+
+func TestFuzzAfterBundles(t *testing.T) {
+	is := is.New(t)
+	config := DefaultConfig()
+	config.Allocation["alice"] = "1000000000000000000000"
+	config.Allocation["bob"] = "1000000000000000000000"
+	config.Allocation["carol"] = "1000000000000000000000"
+	eth, err := New(config, nil)
+	is.NoErr(err)
+	alice, bob, carol := eth.Accounts["alice"], eth.Accounts["bob"], eth.Accounts["carol"]
+	sink, _ := NewAccount("sink", big.NewInt(0))
+
+	bundled, err := carol.CreateSend(sink.Address, big.NewInt(5))
+	is.NoErr(err)
+	base, err := eth.newBuilder(eth.LatestBlock())
+	is.NoErr(err)
+	is.NoErr(base.applyBundle(&txpool.Bundle{Transactions: []*types.Transaction{bundled}}))
+
+	var txs []*types.Transaction
+	for _, acc := range []*Account{alice, bob} {
+		tx, err := acc.CreateSend(sink.Address, big.NewInt(1))
+		is.NoErr(err)
+		txs = append(txs, tx)
+	}
+	// every ordering is checked against the block after its bundles
+	bundleFirst := func(state *state.StateDB, receipts []*types.Receipt) error {
+		if len(receipts) != 3 || receipts[0].TxHash != bundled.Hash() {
+			return errors.New("the bundle isn't at the top of the block")
+		}
+		if state.GetBalance(sink.Address).Cmp(big.NewInt(7)) != 0 {
+			return errors.New("sink wasn't paid by everyone")
+		}
+		return nil
+	}
+	failure, err := fuzzOrderings(base, txs, FuzzConfig{Runs: 10, Invariants: []Invariant{bundleFirst}})
+	is.NoErr(err)
+	is.True(failure == nil)
+	// the base block is left as it was
+	is.Equal(len(base.txs), 1)
+}
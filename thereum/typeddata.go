@@ -0,0 +1,21 @@
+package thereum
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/evan-forbes/ethlab/eip712"
+)
+
+// SignTypedData signs the EIP-712 hash of the typed data. The recovery id of the
+// signature is 27 or 28.
+func (a *Account) SignTypedData(data *eip712.TypedData) ([]byte, error) {
+	return eip712.Sign(a.PrivKey, data)
+}
+
+// SignTypedData signs typed data using an unlocked account
+func (t *Thereum) SignTypedData(addr common.Address, data *eip712.TypedData) ([]byte, error) {
+	acc, has := t.Unlocked(addr)
+	if !has {
+		return nil, ErrLocked
+	}
+	return acc.SignTypedData(data)
+}
@@ -0,0 +1,241 @@
+package thereum
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// ErrLocked is returned when signing with an address that isn't one of the
+// accounts created from the config's allocation
+var ErrLocked = errors.New("unknown account: only accounts from the allocation are unlocked")
+
+// TxArgs describes a transaction to be filled in and signed by an unlocked account.
+// Missing fields are filled using the account's defaults.
+type TxArgs struct {
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"`
+	Gas      *hexutil.Uint64 `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Value    *hexutil.Big    `json:"value"`
+	Nonce    *hexutil.Uint64 `json:"nonce"`
+	Data     *hexutil.Bytes  `json:"data"`
+	Input    *hexutil.Bytes  `json:"input"` // Input is the newer name for Data
+}
+
+// data returns the call data, preferring input over data
+func (args *TxArgs) data() []byte {
+	if args.Input != nil {
+		return *args.Input
+	}
+	if args.Data != nil {
+		return *args.Data
+	}
+	return nil
+}
+
+// nonceTracker hands out the nonces used by the unlocked accounts, so that
+// concurrent requests signed by the server never reuse a nonce
+type nonceTracker struct {
+	next map[common.Address]uint64
+	mu   sync.Mutex
+}
+
+func newNonceTracker() *nonceTracker {
+	return &nonceTracker{next: make(map[common.Address]uint64)}
+}
+
+////////////////////////////////////
+// 		Unlocked Accounts
+//////////////////////////////////
+
+// UnlockedAccounts returns the addresses of the accounts created from the config's
+// allocation, sorted by account name
+func (t *Thereum) UnlockedAccounts() []common.Address {
+	names := make([]string, 0, len(t.Accounts))
+	for name := range t.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]common.Address, len(names))
+	for i, name := range names {
+		out[i] = t.Accounts[name].Address
+	}
+	return out
+}
+
+// Unlocked finds the unlocked account using addr
+func (t *Thereum) Unlocked(addr common.Address) (*Account, bool) {
+	for _, acc := range t.Accounts {
+		if acc.Address == addr {
+			return acc, true
+		}
+	}
+	return nil, false
+}
+
+// SignText signs the data using an unlocked account, after prefixing it with
+// "\x19Ethereum Signed Message:\n" and its length, as used by eth_sign and
+// personal_sign. The recovery id of the signature is 27 or 28.
+func (t *Thereum) SignText(addr common.Address, data []byte) ([]byte, error) {
+	acc, has := t.Unlocked(addr)
+	if !has {
+		return nil, ErrLocked
+	}
+	sig, err := crypto.Sign(accounts.TextHash(data), acc.PrivKey)
+	if err != nil {
+		return nil, err
+	}
+	sig[crypto.RecoveryIDOffset] += 27
+	return sig, nil
+}
+
+// SignTxArgs fills in the missing fields of a transaction and signs it using an
+// unlocked account. The nonce defaults to the next nonce the account can use, but
+// isn't reserved until the transaction is sent.
+func (t *Thereum) SignTxArgs(args TxArgs) (*types.Transaction, error) {
+	t.nonces.mu.Lock()
+	defer t.nonces.mu.Unlock()
+	return t.signTxArgs(args)
+}
+
+// SendTxArgs signs a transaction using an unlocked account and adds it to the
+// txpool. Nonces are handed out one at a time, so concurrent sends from the same
+// account each get their own.
+func (t *Thereum) SendTxArgs(args TxArgs) (*types.Transaction, error) {
+	t.nonces.mu.Lock()
+	defer t.nonces.mu.Unlock()
+	tx, err := t.signTxArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	err = t.AddTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	if next := tx.Nonce() + 1; next > t.nonces.next[args.From] {
+		t.nonces.next[args.From] = next
+	}
+	return tx, nil
+}
+
+// signTxArgs fills in and signs the transaction. Callers must hold t.nonces.mu
+func (t *Thereum) signTxArgs(args TxArgs) (*types.Transaction, error) {
+	acc, has := t.Unlocked(args.From)
+	if !has {
+		return nil, ErrLocked
+	}
+	if args.Data != nil && args.Input != nil && !bytes.Equal(*args.Data, *args.Input) {
+		return nil, errors.New("both data and input were provided, but they differ")
+	}
+	value := new(big.Int)
+	if args.Value != nil {
+		value = args.Value.ToInt()
+	}
+	price := new(big.Int).Set(acc.TxOpts.GasPrice)
+	if args.GasPrice != nil {
+		price = args.GasPrice.ToInt()
+	}
+	var nonce uint64
+	if args.Nonce != nil {
+		nonce = uint64(*args.Nonce)
+	} else {
+		pending, err := t.PendingNonce(args.From)
+		if err != nil {
+			return nil, err
+		}
+		nonce = pending
+		if next := t.nonces.next[args.From]; next > nonce {
+			nonce = next
+		}
+	}
+	var gas uint64
+	if args.Gas != nil {
+		gas = uint64(*args.Gas)
+	} else {
+		estimate, err := t.estimateGas(acc, args.To, value, price, args.data())
+		if err != nil {
+			return nil, err
+		}
+		gas = estimate
+	}
+	var tx *types.Transaction
+	if args.To == nil {
+		tx = types.NewContractCreation(nonce, value, gas, price, args.data())
+	} else {
+		tx = types.NewTransaction(nonce, *args.To, value, gas, price, args.data())
+	}
+	return types.SignTx(tx, t.signer, acc.PrivKey)
+}
+
+// estimateGas finds the gas needed to execute the transaction on top of the latest
+// block. Plain transfers always use 21000 gas.
+func (t *Thereum) estimateGas(acc *Account, to *common.Address, value, price *big.Int, data []byte) (uint64, error) {
+	if to != nil && len(data) == 0 {
+		state := t.LatestState()
+		if len(state.GetCode(*to)) == 0 {
+			return params.TxGas, nil
+		}
+	}
+	// the sender can't use more gas than it can pay for
+	limit := t.blockchain.CurrentBlock().GasLimit()
+	if price.Sign() > 0 {
+		funds := new(big.Int).Sub(t.LatestState().GetBalance(acc.Address), value)
+		if funds.Sign() <= 0 {
+			return 0, errors.New("insufficient funds for transfer")
+		}
+		if allowance := new(big.Int).Div(funds, price); allowance.IsUint64() && allowance.Uint64() < limit {
+			limit = allowance.Uint64()
+		}
+	}
+	nonce, err := t.GetNonce(acc.Address)
+	if err != nil {
+		return 0, err
+	}
+	// executes reports the gas used if the transaction succeeds using the provided gas
+	executes := func(gas uint64) (uint64, bool) {
+		var tx *types.Transaction
+		if to == nil {
+			tx = types.NewContractCreation(nonce, value, gas, price, data)
+		} else {
+			tx = types.NewTransaction(nonce, *to, value, gas, price, data)
+		}
+		signed, err := types.SignTx(tx, t.signer, acc.PrivKey)
+		if err != nil {
+			return 0, false
+		}
+		sim, err := t.Simulate(signed)
+		if err != nil || sim.Receipts[0].Status != types.ReceiptStatusSuccessful {
+			return 0, false
+		}
+		return sim.Receipts[0].GasUsed, true
+	}
+	used, ok := executes(limit)
+	if !ok {
+		return 0, errors.New("gas required exceeds allowance or always failing transaction")
+	}
+	// refunds can make the gas used lower than the gas needed, so search for the
+	// lowest amount of gas that still executes
+	if _, ok := executes(used); ok {
+		return used, nil
+	}
+	lo, hi := used, limit
+	for lo+1 < hi {
+		mid := (lo + hi) / 2
+		if _, ok := executes(mid); ok {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi, nil
+}
@@ -0,0 +1,166 @@
+package txpool
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Bundle is a group of transactions that are included in the same block, one
+// directly after another, or not at all. Bundles are used to simulate flashbots
+// style arbitrage and liquidations.
+type Bundle struct {
+	Transactions []*types.Transaction
+	BlockNumber  *big.Int // BlockNumber targets a specific block, nil targets the next one
+	RevertOnFail bool     // RevertOnFail leaves the whole bundle out if any transaction fails
+}
+
+// Hash returns the keccak256 hash of the bundle's concatenated transaction hashes
+func (b *Bundle) Hash() common.Hash {
+	var data []byte
+	for _, tx := range b.Transactions {
+		data = append(data, tx.Hash().Bytes()...)
+	}
+	return crypto.Keccak256Hash(data)
+}
+
+// Gas returns the combined gas limit of the bundle's transactions
+func (b *Bundle) Gas() uint64 {
+	var gas uint64
+	for _, tx := range b.Transactions {
+		gas = gas + tx.Gas()
+	}
+	return gas
+}
+
+// AddBundle queues a bundle until the block it targets is built. Bundles are released
+// in the order they arrived.
+func (pool *LinkedPool) AddBundle(bundle *Bundle) error {
+	if len(bundle.Transactions) == 0 {
+		return ErrEmptyBundle
+	}
+	if limit := pool.config.PriceLimit; limit != nil {
+		for _, tx := range bundle.Transactions {
+			if tx.GasPrice().Cmp(limit) < 0 {
+				return ErrUnderpriced
+			}
+		}
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.bundles = append(pool.bundles, bundle)
+	return nil
+}
+
+// Requeue puts bundles pulled using Bundles back in the queue, such as when they
+// didn't fit in the block. They're released before any bundles that arrived after
+// them, in the order they were pulled.
+func (pool *LinkedPool) Requeue(bundles []*Bundle) {
+	if len(bundles) == 0 {
+		return
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	queue := make([]*Bundle, 0, len(bundles)+len(pool.bundles))
+	queue = append(queue, bundles...)
+	pool.bundles = append(queue, pool.bundles...)
+}
+
+// Bundles pulls the bundles that can be included in the block with the provided
+// number. Bundles that targeted an earlier block are dropped.
+func (pool *LinkedPool) Bundles(number *big.Int) []*Bundle {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	var out, keep []*Bundle
+	for _, bundle := range pool.bundles {
+		switch {
+		case bundle.BlockNumber == nil || bundle.BlockNumber.Cmp(number) == 0:
+			out = append(out, bundle)
+		case bundle.BlockNumber.Cmp(number) < 0:
+			pool.drop(txSet{Transactions: bundle.Transactions}, ErrBundleExpired, common.Hash{})
+		default:
+			keep = append(keep, bundle)
+		}
+	}
+	pool.bundles = keep
+	return out
+}
+
+// Discard records transactions that were pulled from the pool, but could not be
+// executed. Any nonces handed out with the transactions can be used again.
+func (pool *LinkedPool) Discard(txs []*types.Transaction, reason error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.drop(txSet{Transactions: txs}, reason, common.Hash{})
+	for _, tx := range txs {
+		pool.pulled(tx.Hash())
+		from, err := types.Sender(pool.signer, tx)
+		if err != nil {
+			continue
+		}
+		acc, has := pool.accounts[from]
+		if has && tx.Nonce() < acc.next {
+			acc.next = tx.Nonce()
+			pool.promote(acc)
+		}
+	}
+}
+
+// Restore puts transactions pulled from the pool back after one of their author's
+// earlier transactions couldn't be executed, so they aren't lost to the nonce gap.
+// Each author's transactions wait in the pool until the gap is filled. Transactions
+// pulled by Batch or Take keep the place they first arrived in.
+func (pool *LinkedPool) Restore(txs []*types.Transaction) {
+	var authors []common.Address
+	byAuthor := make(map[common.Address][]*types.Transaction)
+	seqs := make(map[common.Hash]uint64)
+	pool.mu.Lock()
+	for _, tx := range txs {
+		from, err := types.Sender(pool.signer, tx)
+		if err != nil {
+			continue
+		}
+		if _, has := byAuthor[from]; !has {
+			authors = append(authors, from)
+		}
+		byAuthor[from] = append(byAuthor[from], tx)
+		if id, has := pool.pulled(tx.Hash()); has {
+			seqs[tx.Hash()] = id.seq
+		}
+	}
+	pool.mu.Unlock()
+	for _, author := range authors {
+		for _, tx := range byAuthor[author] {
+			pool.restore(author, seqs[tx.Hash()], tx)
+		}
+	}
+}
+
+// Advance lets the pool know that the author's nonces below next have been used
+// outside of the pool, such as by a bundle. Any sets using those nonces are dropped.
+func (pool *LinkedPool) Advance(author common.Address, next uint64) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for hash, id := range pool.taken {
+		if id.address == author && id.nonce < next {
+			delete(pool.taken, hash)
+		}
+	}
+	acc := pool.account(author, next)
+	if next <= acc.next && acc.started {
+		return
+	}
+	acc.next = next
+	acc.started = true
+	for _, queue := range []map[uint64]txSet{acc.pending, acc.queue} {
+		for nonce, set := range queue {
+			if nonce < next {
+				pool.drop(set, ErrNonceTooLow, common.Hash{})
+				pool.remove(acc, set)
+			}
+		}
+	}
+	pool.promote(acc)
+}
@@ -0,0 +1,76 @@
+package txpool
+
+import (
+	"errors"
+	"math/big"
+)
+
+var (
+	// ErrReplaceUnderpriced is returned when a transaction with the same sender and
+	// nonce as a pooled one doesn't pay enough to replace it.
+	ErrReplaceUnderpriced = errors.New("replacement transaction underpriced")
+
+	// ErrReplaced is recorded for pooled transactions that were replaced by a
+	// transaction paying a higher gas price.
+	ErrReplaced = errors.New("transaction replaced")
+
+	// ErrUnderpriced is returned when a transaction's gas price is below the
+	// pool's minimum.
+	ErrUnderpriced = errors.New("transaction underpriced")
+
+	// ErrPoolFull is returned when the pool is full and the transaction doesn't pay
+	// more than the transactions it would have to evict.
+	ErrPoolFull = errors.New("txpool is full")
+
+	// ErrAccountLimit is returned when the sender already has the maximum number of
+	// transactions allowed in the pool.
+	ErrAccountLimit = errors.New("account transaction limit reached")
+
+	// ErrEvicted is recorded for pooled transactions that were evicted to make room
+	// for a better paying transaction.
+	ErrEvicted = errors.New("transaction evicted from full txpool")
+
+	// ErrEmptyBundle is returned when a bundle without any transactions is added.
+	ErrEmptyBundle = errors.New("bundle contains no transactions")
+
+	// ErrBundleExpired is recorded for bundles that missed the block they targeted.
+	ErrBundleExpired = errors.New("bundle target block has passed")
+
+	// ErrBundleReverted is recorded for bundles that were left out of a block because
+	// one of their transactions failed.
+	ErrBundleReverted = errors.New("bundle reverted")
+
+	// ErrNonceTooLow is returned when a transaction's nonce has already been used
+	// or handed off to a block.
+	ErrNonceTooLow = errors.New("nonce too low")
+)
+
+// Config contains the settings used to run a pool of transactions
+type Config struct {
+	// PriceBump is the minimum percentage a replacement transaction must increase
+	// the gas price by to replace a pooled transaction with the same nonce.
+	PriceBump uint64 `json:"price_bump"`
+	// PriceLimit is the minimum gas price accepted by the pool
+	PriceLimit *big.Int `json:"price_limit"`
+	// AccountSlots is the max number of transactions a single account can have
+	// in the pool. Zero means unlimited.
+	AccountSlots int `json:"account_slots"`
+	// GlobalSlots is the max number of transactions held by the pool. Once full, the
+	// cheapest transactions are evicted. Zero means unlimited.
+	GlobalSlots int `json:"global_slots"`
+	// Ordering names the order used to release transactions from different senders:
+	// "price" (default), "fifo", "random", or "reverse".
+	Ordering string `json:"ordering"`
+	// Seed is used to reproduce a random ordering
+	Seed int64 `json:"seed"`
+}
+
+// DefaultConfig returns geth's replacement rules with roomier limits for testing
+func DefaultConfig() Config {
+	return Config{
+		PriceBump:    10,
+		PriceLimit:   big.NewInt(1),
+		AccountSlots: 1024,
+		GlobalSlots:  16384,
+	}
+}
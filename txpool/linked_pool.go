@@ -23,74 +23,465 @@ type txSet struct {
 	ID           *txID
 }
 
-// LinkedPool is an ordered pool of transactions sorted by gas price. It also allows for
-// 'linked' transactions
+// lastNonce returns the nonce of the final transaction in the set. Linked
+// transactions are expected to be sent by the same author using consecutive
+// nonces.
+func (set txSet) lastNonce() uint64 {
+	last := set.Transactions[len(set.Transactions)-1].Nonce()
+	if last < set.ID.nonce {
+		return set.ID.nonce
+	}
+	return last
+}
+
+// Drop records a transaction that left the pool without being executed
+type Drop struct {
+	Tx     *types.Transaction
+	Reason error       // Reason describes why the transaction was dropped
+	By     common.Hash // By is the hash of the replacing transaction, if any
+}
+
+// maxDrops caps the number of dropped transactions remembered by the pool
+const maxDrops = 4096
+
+// NonceFunc returns the next nonce the chain expects from an address
+type NonceFunc func(common.Address) uint64
+
+// account keeps track of the transaction sets sent from a single address. Like
+// geth's pending and queued pools, sets are split into those that are executable
+// given the account's next nonce and those waiting on a nonce gap to be filled.
+type account struct {
+	next    uint64           // nonce of the next set to be executed
+	started bool             // started is true once next is known to be correct
+	slots   int              // number of transactions held for the account
+	head    *txID            // id of the executable set currently in the price order
+	pending map[uint64]txSet // executable sets, contiguous starting at next
+	queue   map[uint64]txSet // future sets, waiting on a nonce gap to fill
+}
+
+func newAccount(next uint64) *account {
+	return &account{
+		next:    next,
+		pending: make(map[uint64]txSet),
+		queue:   make(map[uint64]txSet),
+	}
+}
+
+// lookup finds the set starting with the provided nonce in either queue
+func (acc *account) lookup(nonce uint64) (txSet, bool) {
+	if set, has := acc.pending[nonce]; has {
+		return set, true
+	}
+	set, has := acc.queue[nonce]
+	return set, has
+}
+
+// settle re-splits the account's sets into pending and queued. Sets that continue
+// the nonce sequence starting at next are promoted, any set after a gap is demoted.
+func (acc *account) settle() {
+	all := make(map[uint64]txSet, len(acc.pending)+len(acc.queue))
+	for nonce, set := range acc.queue {
+		all[nonce] = set
+	}
+	for nonce, set := range acc.pending {
+		all[nonce] = set
+	}
+	acc.pending = make(map[uint64]txSet)
+	nonce := acc.next
+	for {
+		set, has := all[nonce]
+		if !has {
+			break
+		}
+		acc.pending[nonce] = set
+		delete(all, nonce)
+		nonce = set.lastNonce() + 1
+	}
+	acc.queue = all
+}
+
+// LinkedPool is an ordered pool of transactions sorted by gas price, or any other
+// Ordering. It also allows for 'linked' transactions. Transactions from the same author
+// are only ever released in nonce order, while the ordering is used to choose between
+// authors.
 type LinkedPool struct {
-	pool         map[common.Address]map[uint64]txSet
-	order        []*txID // maintain gas price order
+	accounts     map[common.Address]*account
+	order        []*txID // maintain the release order of each account's next executable set
 	mu           sync.RWMutex
 	invalidCount int // invalidCount keeps track of the number of replaced transactions
 	signer       types.Signer
+	nonceAt      NonceFunc // nonceAt is used to find the starting nonce of unseen accounts
+	config       Config
+	ordering     Ordering  // ordering decides which account's executable set is released next
+	seq          uint64    // seq counts the number of sets added to the pool, numbering them from 1
+	slots        int       // slots is the number of transactions held in the pool
+	bundles      []*Bundle // bundles waiting for their target block
+
+	drops     map[common.Hash]Drop // drops remembers why recent transactions left the pool
+	dropOrder []common.Hash        // dropOrder is used to forget the oldest drops first
+
+	// batched and taken remember the ids of transactions pulled by the last Batch and
+	// by Take, so restored transactions keep the place they arrived in
+	batched map[common.Hash]*txID
+	taken   map[common.Hash]*txID
 }
 
+// NewLinkedPool issues a new LinkedPool using the default config
 func NewLinkedPool() *LinkedPool {
-	return &LinkedPool{
-		pool:   make(map[common.Address]map[uint64]txSet),
-		signer: types.NewEIP155Signer(big.NewInt(1)),
+	pool, _ := NewLinkedPoolWithConfig(DefaultConfig())
+	return pool
+}
+
+// NewLinkedPoolWithConfig issues a new LinkedPool using the provided config
+func NewLinkedPoolWithConfig(config Config) (*LinkedPool, error) {
+	ordering, err := NewOrdering(config.Ordering, config.Seed)
+	if err != nil {
+		return nil, err
 	}
+	return &LinkedPool{
+		accounts: make(map[common.Address]*account),
+		signer:   types.NewEIP155Signer(big.NewInt(1)),
+		config:   config,
+		ordering: ordering,
+		drops:    make(map[common.Hash]Drop),
+		batched:  make(map[common.Hash]*txID),
+		taken:    make(map[common.Hash]*txID),
+	}, nil
 }
 
+// SetNonceFunc provides the pool with a way to look up the nonce expected by the
+// chain for accounts it has not seen before. Without one, the lowest nonce inserted
+// for an account is assumed to be executable.
+func (pool *LinkedPool) SetNonceFunc(fn NonceFunc) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.nonceAt = fn
+}
+
+// Len returns the number of transaction sets in the pool, executable or not.
 func (pool *LinkedPool) Len() int {
-	return len(pool.order)
+	pending, queued := pool.Stats()
+	return pending + queued
 }
 
-// next retrieves the highest priced transaction/set of transactions
-func (pool *LinkedPool) next() (txSet, bool) {
-	if len(pool.order) == 0 {
-		return txSet{}, false
+// Slots returns the number of transactions in the pool
+func (pool *LinkedPool) Slots() int {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return pool.slots
+}
+
+// Stats returns the number of executable and future transaction sets in the pool
+func (pool *LinkedPool) Stats() (pending int, queued int) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	for _, acc := range pool.accounts {
+		pending = pending + len(acc.pending)
+		queued = queued + len(acc.queue)
 	}
+	return pending, queued
+}
+
+// next retrieves the executable transaction/set of transactions with the highest priority
+func (pool *LinkedPool) next() (common.Address, txSet, bool) {
 	pool.mu.Lock()
 	defer pool.mu.Unlock()
+	for len(pool.order) != 0 {
+		// pop the highest gas price transaction off
+		nextID := pool.order[len(pool.order)-1]
+		pool.order[len(pool.order)-1] = nil
+		pool.order = pool.order[:len(pool.order)-1]
 
-	// pop the highest gas price transaction off
-	nextID := pool.order[len(pool.order)-1]
-	pool.order[len(pool.order)-1] = nil
-	pool.order = pool.order[:len(pool.order)-1]
+		if !nextID.valid {
+			// try again if the tx set has been marked
+			continue
+		}
+
+		// get the tx from the pool
+		acc, has := pool.accounts[nextID.address]
+		if !has {
+			continue
+		}
+		set, has := acc.pending[nextID.nonce]
+		if !has {
+			// if a tx has somehow been removed from the pool but not from the order
+			continue
+		}
+
+		// remove the set from the pool and move on to the account's next nonce
+		acc.head = nil
+		acc.next = set.lastNonce() + 1
+		acc.started = true
+		pool.remove(acc, set)
+
+		return nextID.address, set, true
+	}
+	return common.Address{}, txSet{}, false
+}
 
-	if !nextID.valid {
-		// try again if the tx set has been marked
-		return pool.next()
+// account fetches the account for author, creating one if needed. Must be
+// called with the lock held.
+func (pool *LinkedPool) account(author common.Address, nonce uint64) *account {
+	acc, has := pool.accounts[author]
+	if !has {
+		acc = newAccount(nonce)
+		if pool.nonceAt != nil {
+			acc.next = pool.nonceAt(author)
+			acc.started = true
+		}
+		pool.accounts[author] = acc
+	}
+	// without a way to check the chain, the lowest nonce seen is executable
+	if !acc.started && nonce < acc.next {
+		acc.next = nonce
 	}
+	return acc
+}
 
-	// get the tx from the pool
-	set, has := pool.pool[nextID.address][nextID.nonce]
+// promote settles the account's queues and ensures that its executable head is
+// the only one of its sets in the price order. Must be called with the lock held.
+func (pool *LinkedPool) promote(acc *account) {
+	acc.settle()
+	set, has := acc.pending[acc.next]
+	if has && acc.head == set.ID {
+		return
+	}
+	if acc.head != nil {
+		pool.invalidate(acc.head)
+		acc.head = nil
+	}
 	if !has {
-		// if a tx has somehow been removed from the pool but not from the order
-		return pool.next()
+		return
+	}
+	acc.head = set.ID
+	pool.place(set.ID)
+}
+
+// add puts the set into the account, replacing any set with the same nonce. Sets
+// are numbered when they're first added, so sets put back keep their place in
+// orderings that use arrival. Must be called with the lock held.
+func (pool *LinkedPool) add(acc *account, set txSet) {
+	if old, has := acc.lookup(set.ID.nonce); has {
+		pool.remove(acc, old)
+	}
+	if set.ID.seq == 0 {
+		pool.seq++
+		set.ID.seq = pool.seq
+	}
+	acc.queue[set.ID.nonce] = set
+	acc.slots = acc.slots + len(set.Transactions)
+	pool.slots = pool.slots + len(set.Transactions)
+	pool.promote(acc)
+}
+
+// remove takes the set out of the account. Must be called with the lock held.
+func (pool *LinkedPool) remove(acc *account, set txSet) {
+	delete(acc.pending, set.ID.nonce)
+	delete(acc.queue, set.ID.nonce)
+	acc.slots = acc.slots - len(set.Transactions)
+	pool.slots = pool.slots - len(set.Transactions)
+	pool.promote(acc)
+}
+
+// evict makes room for n more transactions by dropping the cheapest sets in the
+// pool. Nothing is evicted unless every set that has to go is cheaper than the
+// incoming set. The set being replaced, if any, is skipped, as are the incoming
+// author's lower nonces, which would leave the incoming set behind a nonce gap.
+// Must be called with the lock held.
+func (pool *LinkedPool) evict(n int, incoming txSet, replaced *txID) error {
+	type candidate struct {
+		acc *account
+		set txSet
+	}
+	var cands []candidate
+	for _, acc := range pool.accounts {
+		for _, queue := range []map[uint64]txSet{acc.pending, acc.queue} {
+			for _, set := range queue {
+				if set.ID == replaced {
+					continue
+				}
+				if set.ID.address == incoming.ID.address && set.ID.nonce < incoming.ID.nonce {
+					continue
+				}
+				cands = append(cands, candidate{acc: acc, set: set})
+			}
+		}
+	}
+	// evict the cheapest sets first, and the latest nonces of equally priced sets
+	sort.Slice(cands, func(i, j int) bool {
+		switch cands[i].set.ID.gasPrice.Cmp(cands[j].set.ID.gasPrice) {
+		case -1:
+			return true
+		case 1:
+			return false
+		}
+		return cands[i].set.ID.nonce > cands[j].set.ID.nonce
+	})
+	var freed int
+	var evictions []candidate
+	for _, cand := range cands {
+		if freed >= n {
+			break
+		}
+		if cand.set.ID.gasPrice.Cmp(incoming.ID.gasPrice) >= 0 {
+			return ErrPoolFull
+		}
+		evictions = append(evictions, cand)
+		freed = freed + len(cand.set.Transactions)
+	}
+	if freed < n {
+		return ErrPoolFull
+	}
+	for _, ev := range evictions {
+		pool.drop(ev.set, ErrEvicted, incoming.Transactions[0].Hash())
+		pool.remove(ev.acc, ev.set)
+	}
+	return nil
+}
+
+// place inserts the id into the ordered set. Must be called with the lock held.
+func (pool *LinkedPool) place(id *txID) {
+	// don't attempt to search and insert the txID if there're none to search
+	if len(pool.order) == 0 {
+		pool.order = append(pool.order, id)
+		return
+	}
+	// insert the transaction into the ordered set
+	i := search(pool.order, id, pool.ordering)
+	pool.order = append(pool.order, nil)
+	copy(pool.order[i+1:], pool.order[i:])
+	pool.order[i] = id
+}
+
+// invalidate marks an id in the price order to be skipped, occasionally cleaning
+// the order to keep it from filling up with invalid ids. Must be called with the
+// lock held.
+func (pool *LinkedPool) invalidate(id *txID) {
+	id.valid = false
+	pool.invalidCount++
+	if pool.invalidCount > len(pool.order)/2 {
+		pool.clean()
 	}
+}
 
-	// remove the transaction from the pool
-	delete(pool.pool[nextID.address], nextID.nonce)
+// clean removes all invalid ids from the price order. Must be called with the
+// lock held.
+func (pool *LinkedPool) clean() {
+	valid := pool.order[:0]
+	for _, id := range pool.order {
+		if id.valid {
+			valid = append(valid, id)
+		}
+	}
+	for i := len(valid); i < len(pool.order); i++ {
+		pool.order[i] = nil
+	}
+	pool.order = valid
+	pool.invalidCount = 0
+}
 
-	return set, true
+// drop records why a set of transactions left the pool. Must be called with the
+// lock held.
+func (pool *LinkedPool) drop(set txSet, reason error, by common.Hash) {
+	for _, tx := range set.Transactions {
+		hash := tx.Hash()
+		if _, has := pool.drops[hash]; !has {
+			pool.dropOrder = append(pool.dropOrder, hash)
+		}
+		pool.drops[hash] = Drop{Tx: tx, Reason: reason, By: by}
+	}
+	// forget the oldest drops
+	for len(pool.dropOrder) > maxDrops {
+		delete(pool.drops, pool.dropOrder[0])
+		pool.dropOrder[0] = common.Hash{}
+		pool.dropOrder = pool.dropOrder[1:]
+	}
+}
+
+// Dropped reports if, and why, a transaction recently left the pool without
+// being executed.
+func (pool *LinkedPool) Dropped(hash common.Hash) (Drop, bool) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	d, has := pool.drops[hash]
+	return d, has
+}
+
+// replaces checks if the new gas price is high enough to replace a pooled set with
+// the old gas price, using the configured minimum price bump.
+func (pool *LinkedPool) replaces(oldPrice, newPrice *big.Int) bool {
+	if newPrice.Cmp(oldPrice) <= 0 {
+		return false
+	}
+	// threshold = old * (100 + bump) / 100
+	threshold := new(big.Int).Mul(oldPrice, new(big.Int).SetUint64(100+pool.config.PriceBump))
+	threshold.Div(threshold, big.NewInt(100))
+	return newPrice.Cmp(threshold) >= 0
 }
 
 // The tx is some how not being added to the pool
 
 // Insert adds a set of transactions to the ordered pool. If multiple transactions are provided
 // they are treated as 'linked'. (Linked transactions run individually one after another and will be sorted
-// using the lowest gas price of all txs provided).
-func (pool *LinkedPool) Insert(author common.Address, txs ...*types.Transaction) {
+// using the lowest gas price of all txs provided). Sets are only executable once every
+// lower nonce from the same author has been executed. A set replaces a pooled one
+// with the same author and nonce only if it raises the gas price by at least the
+// configured price bump, otherwise ErrReplaceUnderpriced is returned. Once the pool
+// is full, the cheapest sets are evicted to make room for better paying ones.
+func (pool *LinkedPool) Insert(author common.Address, txs ...*types.Transaction) error {
 	// don't insert nothing
 	if len(txs) == 0 {
-		return
+		return nil
+	}
+	set := newTxSet(author, txs)
+	nonce := set.ID.nonce
+	if pool.config.PriceLimit != nil && set.ID.gasPrice.Cmp(pool.config.PriceLimit) < 0 {
+		return ErrUnderpriced
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	acc := pool.account(author, nonce)
+	// the nonce has already been used or handed off to a block
+	if nonce < acc.next {
+		return ErrNonceTooLow
+	}
+	// check to see if this transaction already exists
+	added := len(txs)
+	oldtx, has := acc.lookup(nonce)
+	if has {
+		if !pool.replaces(oldtx.ID.gasPrice, set.ID.gasPrice) {
+			return ErrReplaceUnderpriced
+		}
+		added = added - len(oldtx.Transactions)
+	}
+	// enforce the slot limits, making room if possible
+	if limit := pool.config.AccountSlots; limit != 0 && acc.slots+added > limit {
+		return ErrAccountLimit
+	}
+	if limit := pool.config.GlobalSlots; limit != 0 && pool.slots+added > limit {
+		err := pool.evict(pool.slots+added-limit, set, oldtx.ID)
+		if err != nil {
+			return err
+		}
+	}
+	if has {
+		pool.drop(oldtx, ErrReplaced, txs[0].Hash())
 	}
+
+	//// add the transaction in the pool, settling which queue it belongs to ////
+	pool.add(acc, set)
+	return nil
+}
+
+// newTxSet combines the transactions into a set using the nonce of the first
+func newTxSet(author common.Address, txs []*types.Transaction) txSet {
 	// combine gas prices and limits for multiple txs
-	gsprc := big.NewInt(1)
+	gsprc := txs[0].GasPrice()
 	var gslmt uint64
 	for _, tx := range txs {
 		// use the lowest gas price of all the transactions
-		if gsprc.Cmp(tx.GasPrice()) < 0 {
+		if gsprc.Cmp(tx.GasPrice()) > 0 {
 			gsprc = tx.GasPrice()
 		}
 		// add up the total gas limit of all transactions
@@ -107,71 +498,106 @@ func (pool *LinkedPool) Insert(author common.Address, txs ...*types.Transaction)
 		valid:    true,
 	}
 	// form set
-	set := txSet{
+	return txSet{
 		Transactions: txs,
 		ID:           id,
 	}
+}
 
+// restore puts the unused remainder of a set back into the pool as the author's
+// next executable set, keeping the arrival number of the original set. The remainder
+// has already been accepted, so it's exempt from the pool's limits.
+func (pool *LinkedPool) restore(author common.Address, seq uint64, txs ...*types.Transaction) {
+	if len(txs) == 0 {
+		return
+	}
+	set := newTxSet(author, txs)
+	set.ID.seq = seq
 	pool.mu.Lock()
 	defer pool.mu.Unlock()
-	// check to see if this transaction already exists
-	_, has := pool.pool[author]
-	if !has {
-		pool.pool[author] = make(map[uint64]txSet)
+	acc := pool.account(author, set.ID.nonce)
+	if set.ID.nonce < acc.next {
+		acc.next = set.ID.nonce
 	}
-	oldtx, has := pool.pool[author][nonce]
-	if has {
-		// if the gas price is not larger, don't do anything
-		if oldtx.ID.gasPrice.Cmp(gsprc) != 1 {
-			return
-		}
-		// mark the old transaction as invalid
-		oldtx.ID.valid = false
+	pool.add(acc, set)
+}
 
-		// count invalid txs to make sure we don't call clean too often
-		pool.invalidCount++
+// pulled finds the id of a transaction pulled by Batch or Take, forgetting it. Must
+// be called with the lock held.
+func (pool *LinkedPool) pulled(hash common.Hash) (*txID, bool) {
+	if id, has := pool.batched[hash]; has {
+		delete(pool.batched, hash)
+		return id, true
+	}
+	if id, has := pool.taken[hash]; has {
+		delete(pool.taken, hash)
+		return id, true
+	}
+	return nil, false
+}
 
-		if pool.invalidCount > 100 {
-			clean(pool.order)
-			pool.invalidCount = 0
+// Take removes the transactions from the pool without recording them as dropped,
+// such as when they're moved into a bundle. Each one must have been inserted on its
+// own. Nothing is removed unless every transaction is found.
+func (pool *LinkedPool) Take(txs []*types.Transaction) bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	type taken struct {
+		acc *account
+		set txSet
+	}
+	var found []taken
+	for _, tx := range txs {
+		from, err := types.Sender(pool.signer, tx)
+		if err != nil {
+			return false
 		}
+		acc, has := pool.accounts[from]
+		if !has {
+			return false
+		}
+		set, has := acc.lookup(tx.Nonce())
+		if !has || len(set.Transactions) != 1 || set.Transactions[0].Hash() != tx.Hash() {
+			return false
+		}
+		found = append(found, taken{acc: acc, set: set})
 	}
-
-	//// add the transaction in the pool ////
-	pool.pool[author][nonce] = set
-
-	// don't attempt to search and insert the txID if there're none to search
-	if len(pool.order) == 0 {
-		pool.order = append(pool.order, id)
-		return
+	for _, t := range found {
+		pool.remove(t.acc, t.set)
+		pool.taken[t.set.Transactions[0].Hash()] = t.set.ID
 	}
-
-	// insert the transaction into the ordered set
-	i := search(pool.order, gsprc)
-	pool.order = append(pool.order, nil)
-	copy(pool.order[i+1:], pool.order[i:])
-	pool.order[i] = id
-	return
+	return true
 }
 
 // The batching function could be causing a single tx to be stuck in the pool, because the gas limit is too high
 
-// Batch will get the maximum transactions from a linked pool for the provided gas limit
+// Batch will get the maximum transactions from a linked pool for the provided gas limit.
+// A sender's transactions are always returned in nonce order.
 func (pool *LinkedPool) Batch(gasLimit uint64) []*types.Transaction {
 	var gasCount uint64
 	var out []*types.Transaction
+	batched := make(map[common.Hash]*txID)
+	defer func() {
+		pool.mu.Lock()
+		pool.batched = batched
+		pool.mu.Unlock()
+	}()
 	for {
-		set, has := pool.next()
+		author, set, has := pool.next()
 		if !has {
 			break
 		}
+		for _, tx := range set.Transactions {
+			batched[tx.Hash()] = set.ID
+		}
 
 		for i, tx := range set.Transactions {
 			gasCount = gasCount + tx.Gas()
 			if gasCount > gasLimit {
-				set.Transactions = set.Transactions[i:]
-				from, _ := pool.signer.Sender(tx)
-				pool.Insert(from, set.Transactions...)
+				for _, rest := range set.Transactions[i:] {
+					delete(batched, rest.Hash())
+				}
+				pool.restore(author, set.ID.seq, set.Transactions[i:]...)
 				return out
 			}
 			out = append(out, tx)
@@ -180,13 +606,26 @@ func (pool *LinkedPool) Batch(gasLimit uint64) []*types.Transaction {
 	return out
 }
 
-func search(order []*txID, price *big.Int) (n int) {
+// search finds the index to insert id at, just below any id it should be released after
+func search(order []*txID, id *txID, ordering Ordering) (n int) {
 	sfunc := func(i int) bool {
-		return order[i].gasPrice.Cmp(price) >= 0
+		return !ordering.Less(order[i].info(), id.info())
 	}
 	return sort.Search(len(order), sfunc)
 }
 
+// SetOrdering replaces the order sets are released in, such as with an Ordering
+// implemented outside of this package
+func (pool *LinkedPool) SetOrdering(ordering Ordering) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.ordering = ordering
+	pool.clean()
+	sort.SliceStable(pool.order, func(i, j int) bool {
+		return ordering.Less(pool.order[i].info(), pool.order[j].info())
+	})
+}
+
 // func insertOrder(s []*txID, i int, n *txID) {
 
 // }
@@ -0,0 +1,112 @@
+package txpool
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Ordering decides which executable transaction set a pool releases next. Orderings
+// only choose between senders, a sender's own transactions are always released in
+// nonce order. Testing contracts against different orderings helps find transaction
+// ordering dependencies.
+type Ordering interface {
+	// Less reports whether set a should be released after set b
+	Less(a, b TxInfo) bool
+}
+
+// TxInfo describes an executable transaction set to an Ordering
+type TxInfo struct {
+	Address  common.Address
+	Nonce    uint64   // Nonce is the nonce of the set's first transaction
+	GasPrice *big.Int // GasPrice is the lowest gas price in the set
+	Seq      uint64   // Seq counts the sets added to the pool, so lower sets arrived earlier
+}
+
+// info describes the id to an Ordering
+func (id *txID) info() TxInfo {
+	return TxInfo{Address: id.address, Nonce: id.nonce, GasPrice: id.gasPrice, Seq: id.seq}
+}
+
+// Supported ordering names for Config.Ordering
+const (
+	OrderPrice   = "price"
+	OrderFIFO    = "fifo"
+	OrderRandom  = "random"
+	OrderReverse = "reverse"
+)
+
+// NewOrdering looks up an ordering by name. The seed is only used for random
+// orderings. An empty name defaults to gas price priority.
+func NewOrdering(name string, seed int64) (Ordering, error) {
+	switch name {
+	case "", OrderPrice:
+		return PriceOrdering{}, nil
+	case OrderFIFO:
+		return FIFOOrdering{}, nil
+	case OrderRandom:
+		return RandomOrdering{Seed: seed}, nil
+	case OrderReverse:
+		return ReverseOrdering{}, nil
+	}
+	return nil, fmt.Errorf("unknown txpool ordering: %s", name)
+}
+
+// PriceOrdering releases the highest gas price first, breaking ties by arrival
+type PriceOrdering struct{}
+
+// Less fulfills the Ordering interface
+func (PriceOrdering) Less(a, b TxInfo) bool {
+	switch a.GasPrice.Cmp(b.GasPrice) {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+	return a.Seq > b.Seq
+}
+
+// FIFOOrdering releases transactions strictly in the order they arrived
+type FIFOOrdering struct{}
+
+// Less fulfills the Ordering interface
+func (FIFOOrdering) Less(a, b TxInfo) bool {
+	return a.Seq > b.Seq
+}
+
+// RandomOrdering releases transactions in a random order that can be reproduced
+// using the same seed and arrival order.
+type RandomOrdering struct {
+	Seed int64
+}
+
+// Less fulfills the Ordering interface
+func (o RandomOrdering) Less(a, b TxInfo) bool {
+	ka, kb := o.key(a), o.key(b)
+	if ka == kb {
+		return a.Seq > b.Seq
+	}
+	return ka < kb
+}
+
+// key hashes the seed and arrival number of the set
+func (o RandomOrdering) key(info TxInfo) uint64 {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(o.Seed))
+	binary.BigEndian.PutUint64(buf[8:], info.Seq)
+	h := fnv.New64a()
+	h.Write(buf[:])
+	return h.Sum64()
+}
+
+// ReverseOrdering is an adversarial ordering that releases the lowest gas price
+// first, breaking ties by the latest arrival.
+type ReverseOrdering struct{}
+
+// Less fulfills the Ordering interface
+func (ReverseOrdering) Less(a, b TxInfo) bool {
+	return PriceOrdering{}.Less(b, a)
+}
@@ -9,14 +9,52 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
-// Pooler descibes the methods expected by Thereum to interact with a pool of transactions.
-// Note: Pooler is currently unused, but could replace TxPool
+// Pooler descibes the methods expected by Thereum to build blocks from a pool of
+// transactions. LinkedPool is the default Pooler. Thereum also uses the optional
+// Dropper, NonceSetter, Bundler and Snapshotter interfaces when a Pooler has them.
 type Pooler interface {
-	// Insert adds the transaction to the pool
-	Insert(author common.Address, tx *types.Transaction)
-	//
-	GasPrice(step int) (prices []*big.Int)
-	Next() ([]*types.Transaction, bool)
+	// Insert adds the transaction(s) to the pool
+	Insert(author common.Address, txs ...*types.Transaction) error
+	// Batch pulls the next transactions to fill a block with the provided gas limit
+	Batch(gasLimit uint64) []*types.Transaction
+	// Restore puts transactions pulled from the pool back, after an earlier one failed
+	Restore(txs []*types.Transaction)
+}
+
+// Dropper is a Pooler that keeps track of transactions that left it without being
+// executed
+type Dropper interface {
+	// Discard records transactions pulled from the pool that couldn't be executed
+	Discard(txs []*types.Transaction, reason error)
+	// Dropped reports if, and why, a transaction left the pool without being executed
+	Dropped(hash common.Hash) (Drop, bool)
+}
+
+// NonceSetter is a Pooler that needs to look up where each new sender's nonces start
+type NonceSetter interface {
+	// SetNonceFunc provides a way to look up the next nonce of an account
+	SetNonceFunc(fn NonceFunc)
+}
+
+// Bundler is a Pooler that also holds bundles of transactions, which Thereum places
+// at the top of blocks and uses to carry out attacks
+type Bundler interface {
+	// AddBundle queues a bundle of transactions to be included together
+	AddBundle(bundle *Bundle) error
+	// Bundles pulls the bundles that can be included in the block with the provided number
+	Bundles(number *big.Int) []*Bundle
+	// Requeue puts bundles pulled from the pool back, ahead of any newer bundles
+	Requeue(bundles []*Bundle)
+	// Take removes pooled transactions without dropping them, reporting if they were all found
+	Take(txs []*types.Transaction) bool
+	// Advance drops the author's transactions using nonces below next
+	Advance(author common.Address, next uint64)
+}
+
+// Snapshotter is a Pooler that can copy its contents
+type Snapshotter interface {
+	// Snapshot copies the current contents of the pool
+	Snapshot() *Snapshot
 }
 
 // // Batch returns the max number of txs from the pool without overflowing the
@@ -46,7 +84,7 @@ type TxPool struct {
 	Order        []*txID // Order maintains
 	mu           sync.RWMutex
 	invalidCount int // invalidCount keeps track of the number of replaced transactions
-	maxSize      int // maxSize specs the max number of txs in the pool
+	maxSize      int // maxSize specs the max number of txs in the pool, zero means unlimited
 }
 
 type setTx struct {
@@ -60,13 +98,20 @@ type txID struct {
 	gasPrice *big.Int
 	gasUsed  uint64
 	valid    bool
+	seq      uint64 // seq is the order that the transaction arrived in
 }
 
-// New inits a new TxPool
+// New inits a new TxPool using the default config
 func New() *TxPool {
+	return NewWithConfig(DefaultConfig())
+}
+
+// NewWithConfig inits a new TxPool that holds at most the config's GlobalSlots
+// transactions
+func NewWithConfig(config Config) *TxPool {
 	return &TxPool{
 		Pool:    make(map[common.Address]map[uint64]setTx),
-		maxSize: 100000000000,
+		maxSize: config.GlobalSlots,
 	}
 }
 
@@ -117,6 +162,10 @@ func (pool *TxPool) Insert(author common.Address, tx *types.Transaction) {
 		pool.Pool[author] = make(map[uint64]setTx)
 	}
 	oldtx, has := pool.Pool[author][nonce]
+	// drop new transactions once the pool is full
+	if !has && pool.maxSize != 0 && len(pool.Order) >= pool.maxSize {
+		return
+	}
 	if has {
 		// if the gas price is not larger, don't do anything
 		if oldtx.tx.GasPrice().Cmp(tx.GasPrice()) != 1 {
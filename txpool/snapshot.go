@@ -0,0 +1,103 @@
+package txpool
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// PooledTx is a transaction held by the pool, along with the linked set it belongs to
+type PooledTx struct {
+	Tx    *types.Transaction
+	Set   common.Hash // Set is the hash of the first transaction in the linked set
+	Index int         // Index is the position of the transaction within its set
+	Size  int         // Size is the number of transactions in the set
+}
+
+// Linked reports if the transaction was inserted alongside other transactions
+func (ptx *PooledTx) Linked() bool {
+	return ptx.Size > 1
+}
+
+// Snapshot is a read-only copy of the pool's contents taken at a single point in
+// time. Transactions are grouped by sender, then by nonce. Pending transactions are
+// executable, queued transactions are waiting on a nonce gap to be filled.
+type Snapshot struct {
+	Pending map[common.Address]map[uint64]*PooledTx
+	Queued  map[common.Address]map[uint64]*PooledTx
+	Bundles []*Bundle
+}
+
+func newSnapshot() *Snapshot {
+	return &Snapshot{
+		Pending: make(map[common.Address]map[uint64]*PooledTx),
+		Queued:  make(map[common.Address]map[uint64]*PooledTx),
+	}
+}
+
+// Len returns the number of pending and queued transactions in the snapshot
+func (s *Snapshot) Len() (pending int, queued int) {
+	for _, txs := range s.Pending {
+		pending = pending + len(txs)
+	}
+	for _, txs := range s.Queued {
+		queued = queued + len(txs)
+	}
+	return pending, queued
+}
+
+// Lookup finds a transaction in the snapshot by hash, reporting if it is pending
+func (s *Snapshot) Lookup(hash common.Hash) (ptx *PooledTx, pending bool, has bool) {
+	if ptx, has := lookup(s.Pending, hash); has {
+		return ptx, true, true
+	}
+	ptx, has = lookup(s.Queued, hash)
+	return ptx, false, has
+}
+
+func lookup(group map[common.Address]map[uint64]*PooledTx, hash common.Hash) (*PooledTx, bool) {
+	for _, txs := range group {
+		for _, ptx := range txs {
+			if ptx.Tx.Hash() == hash {
+				return ptx, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// addSet copies each transaction in the set into the group under author
+func addSet(group map[common.Address]map[uint64]*PooledTx, author common.Address, set txSet) {
+	txs, has := group[author]
+	if !has {
+		txs = make(map[uint64]*PooledTx)
+		group[author] = txs
+	}
+	first := set.Transactions[0].Hash()
+	for i, tx := range set.Transactions {
+		txs[tx.Nonce()] = &PooledTx{
+			Tx:    tx,
+			Set:   first,
+			Index: i,
+			Size:  len(set.Transactions),
+		}
+	}
+}
+
+// Snapshot copies the contents of the pool. The pool is locked for the duration of
+// the copy, so the snapshot is consistent, but does not reflect later changes.
+func (pool *LinkedPool) Snapshot() *Snapshot {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	snap := newSnapshot()
+	for author, acc := range pool.accounts {
+		for _, set := range acc.pending {
+			addSet(snap.Pending, author, set)
+		}
+		for _, set := range acc.queue {
+			addSet(snap.Queued, author, set)
+		}
+	}
+	snap.Bundles = make([]*Bundle, len(pool.bundles))
+	copy(snap.Bundles, pool.bundles)
+	return snap
+}
@@ -137,7 +137,7 @@ func TestLinkedInsert(t *testing.T) {
 	lastPrice := big.NewInt(1000000000000000000)
 	// ensure that each tx is sorted properly
 	for i := 0; i < len(txs); i++ {
-		set, _ := pool.next()
+		_, set, _ := pool.next()
 		is.True(set.ID.gasPrice.Cmp(lastPrice) < 0)
 		lastPrice = set.ID.gasPrice
 	}
@@ -177,3 +177,422 @@ func TestRemove(t *testing.T) {
 	is.Equal(n.gasPrice.String(), s[1].gasPrice.String())
 	// is.Equal(n.gasPrice.String(), s[6].gasPrice.String())
 }
+
+// signedTx creates a signed eth transfer from usr with the provided nonce and gas price
+func signedTx(t *testing.T, usr *module.User, nonce uint64, price int64) *types.Transaction {
+	tx, err := usr.Sign(types.NewTransaction(nonce, common.Address{}, big.NewInt(1), 21000, big.NewInt(price), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestNonceGap(t *testing.T) {
+	is := is.New(t)
+	usr, err := module.NewUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewLinkedPool()
+	pool.SetNonceFunc(func(common.Address) uint64 { return 0 })
+
+	// nonces 2 and 3 can't be executed until 0 and 1 arrive
+	pool.Insert(usr.From, signedTx(t, usr, 2, 10))
+	pool.Insert(usr.From, signedTx(t, usr, 3, 10))
+	pending, queued := pool.Stats()
+	is.Equal(pending, 0)
+	is.Equal(queued, 2)
+	is.Equal(len(pool.Batch(1000000)), 0)
+
+	pool.Insert(usr.From, signedTx(t, usr, 0, 10))
+	txs := pool.Batch(1000000)
+	is.Equal(len(txs), 1)
+	is.Equal(txs[0].Nonce(), uint64(0))
+
+	// filling the gap promotes the queued transactions
+	pool.Insert(usr.From, signedTx(t, usr, 1, 10))
+	pending, queued = pool.Stats()
+	is.Equal(pending, 3)
+	is.Equal(queued, 0)
+	txs = pool.Batch(1000000)
+	is.Equal(len(txs), 3)
+	for i, tx := range txs {
+		is.Equal(tx.Nonce(), uint64(i+1))
+	}
+	is.Equal(pool.Len(), 0)
+}
+
+func TestBatchNonceOrder(t *testing.T) {
+	is := is.New(t)
+	alice, err := module.NewUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := module.NewUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewLinkedPool()
+	pool.SetNonceFunc(func(common.Address) uint64 { return 0 })
+
+	// alice's second tx pays the most, but can't be run before her first
+	pool.Insert(alice.From, signedTx(t, alice, 1, 100))
+	pool.Insert(alice.From, signedTx(t, alice, 0, 1))
+	pool.Insert(bob.From, signedTx(t, bob, 0, 50))
+
+	txs := pool.Batch(1000000)
+	is.Equal(len(txs), 3)
+	signer := types.NewEIP155Signer(big.NewInt(1))
+	var senders []common.Address
+	for _, tx := range txs {
+		from, err := signer.Sender(tx)
+		is.NoErr(err)
+		senders = append(senders, from)
+	}
+	is.Equal(senders[0], bob.From)
+	is.Equal(senders[1], alice.From)
+	is.Equal(txs[1].Nonce(), uint64(0))
+	is.Equal(senders[2], alice.From)
+	is.Equal(txs[2].Nonce(), uint64(1))
+}
+
+func TestBatchGasLimit(t *testing.T) {
+	is := is.New(t)
+	usr, err := module.NewUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewLinkedPool()
+	for i := uint64(0); i < 3; i++ {
+		pool.Insert(usr.From, signedTx(t, usr, i, 10))
+	}
+	// only two transfers fit, the last should be kept for the next batch
+	txs := pool.Batch(42000)
+	is.Equal(len(txs), 2)
+	txs = pool.Batch(42000)
+	is.Equal(len(txs), 1)
+	is.Equal(txs[0].Nonce(), uint64(2))
+}
+
+func TestReplacement(t *testing.T) {
+	is := is.New(t)
+	usr, err := module.NewUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := NewLinkedPoolWithConfig(Config{PriceBump: 10})
+	is.NoErr(err)
+
+	old := signedTx(t, usr, 0, 100)
+	is.NoErr(pool.Insert(usr.From, old))
+
+	// a lower, equal, or insufficiently bumped price is rejected
+	is.Equal(pool.Insert(usr.From, signedTx(t, usr, 0, 90)), ErrReplaceUnderpriced)
+	is.Equal(pool.Insert(usr.From, signedTx(t, usr, 0, 100)), ErrReplaceUnderpriced)
+	is.Equal(pool.Insert(usr.From, signedTx(t, usr, 0, 109)), ErrReplaceUnderpriced)
+	_, dropped := pool.Dropped(old.Hash())
+	is.True(!dropped)
+
+	replacement := signedTx(t, usr, 0, 110)
+	is.NoErr(pool.Insert(usr.From, replacement))
+	drop, dropped := pool.Dropped(old.Hash())
+	is.True(dropped)
+	is.Equal(drop.Reason, ErrReplaced)
+	is.Equal(drop.By, replacement.Hash())
+
+	txs := pool.Batch(1000000)
+	is.Equal(len(txs), 1)
+	is.Equal(txs[0].Hash(), replacement.Hash())
+
+	// the nonce has been used up
+	is.Equal(pool.Insert(usr.From, signedTx(t, usr, 0, 1000)), ErrNonceTooLow)
+}
+
+func TestPoolLimits(t *testing.T) {
+	is := is.New(t)
+	var users []*module.User
+	for i := 0; i < 4; i++ {
+		usr, err := module.NewUser()
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, usr)
+	}
+	pool, err := NewLinkedPoolWithConfig(Config{
+		PriceBump:    10,
+		PriceLimit:   big.NewInt(5),
+		AccountSlots: 1,
+		GlobalSlots:  2,
+	})
+	is.NoErr(err)
+	is.Equal(pool.Insert(users[0].From, signedTx(t, users[0], 0, 4)), ErrUnderpriced)
+
+	cheap := signedTx(t, users[0], 0, 10)
+	is.NoErr(pool.Insert(users[0].From, cheap))
+	is.NoErr(pool.Insert(users[1].From, signedTx(t, users[1], 0, 20)))
+	is.Equal(pool.Insert(users[0].From, signedTx(t, users[0], 1, 100)), ErrAccountLimit)
+
+	// the pool is full, so only txs paying more than the cheapest get in
+	is.Equal(pool.Insert(users[2].From, signedTx(t, users[2], 0, 10)), ErrPoolFull)
+	is.NoErr(pool.Insert(users[3].From, signedTx(t, users[3], 0, 30)))
+	is.Equal(pool.Slots(), 2)
+	drop, dropped := pool.Dropped(cheap.Hash())
+	is.True(dropped)
+	is.Equal(drop.Reason, ErrEvicted)
+
+	txs := pool.Batch(1000000)
+	is.Equal(len(txs), 2)
+	is.Equal(txs[0].GasPrice().Int64(), int64(30))
+	is.Equal(txs[1].GasPrice().Int64(), int64(20))
+	is.Equal(pool.Slots(), 0)
+}
+
+func TestLinkedPoolInterfaces(t *testing.T) {
+	is := is.New(t)
+	var pool Pooler = NewLinkedPool()
+	_, ok := pool.(Dropper)
+	is.True(ok)
+	_, ok = pool.(NonceSetter)
+	is.True(ok)
+	_, ok = pool.(Bundler)
+	is.True(ok)
+	_, ok = pool.(Snapshotter)
+	is.True(ok)
+}
+
+func TestTxPoolMaxSize(t *testing.T) {
+	is := is.New(t)
+	usr, err := module.NewUser()
+	is.NoErr(err)
+	pool := NewWithConfig(Config{GlobalSlots: 1})
+	pool.Insert(usr.From, signedTx(t, usr, 0, 10))
+	pool.Insert(usr.From, signedTx(t, usr, 1, 10))
+	is.Equal(len(pool.Order), 1)
+}
+
+func TestEvictNonceGap(t *testing.T) {
+	is := is.New(t)
+	usr, err := module.NewUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := NewLinkedPoolWithConfig(Config{GlobalSlots: 2})
+	is.NoErr(err)
+	pool.SetNonceFunc(func(common.Address) uint64 { return 0 })
+	is.NoErr(pool.Insert(usr.From, signedTx(t, usr, 0, 10)))
+	is.NoErr(pool.Insert(usr.From, signedTx(t, usr, 1, 10)))
+
+	// evicting a lower nonce would leave the new tx unexecutable
+	is.Equal(pool.Insert(usr.From, signedTx(t, usr, 2, 100)), ErrPoolFull)
+	is.Equal(pool.Slots(), 2)
+	txs := pool.Batch(1000000)
+	is.Equal(len(txs), 2)
+}
+
+func TestRestoreAfterFailure(t *testing.T) {
+	is := is.New(t)
+	usr, err := module.NewUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewLinkedPool()
+	pool.SetNonceFunc(func(common.Address) uint64 { return 0 })
+	is.NoErr(pool.Insert(usr.From, signedTx(t, usr, 0, 10)))
+	is.NoErr(pool.Insert(usr.From, signedTx(t, usr, 1, 10)))
+	txs := pool.Batch(1000000)
+	is.Equal(len(txs), 2)
+
+	// the first tx failed, so the second waits for its nonce to be used again
+	pool.Discard(txs[:1], ErrEvicted)
+	pool.Restore(txs[1:])
+	pending, queued := pool.Stats()
+	is.Equal(pending, 0)
+	is.Equal(queued, 1)
+
+	is.NoErr(pool.Insert(usr.From, signedTx(t, usr, 0, 20)))
+	txs = pool.Batch(1000000)
+	is.Equal(len(txs), 2)
+	is.Equal(txs[0].Nonce(), uint64(0))
+	is.Equal(txs[1].Nonce(), uint64(1))
+}
+
+func TestRequeueBundles(t *testing.T) {
+	is := is.New(t)
+	usr, err := module.NewUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewLinkedPool()
+	var bundles []*Bundle
+	for i := uint64(0); i < 3; i++ {
+		bundles = append(bundles, &Bundle{Transactions: []*types.Transaction{signedTx(t, usr, i, 10)}})
+	}
+	is.NoErr(pool.AddBundle(bundles[0]))
+	is.NoErr(pool.AddBundle(bundles[1]))
+	pulled := pool.Bundles(big.NewInt(1))
+	is.Equal(len(pulled), 2)
+
+	// bundles put back are released before newer ones, in their original order
+	is.NoErr(pool.AddBundle(bundles[2]))
+	pool.Requeue(pulled)
+	is.Equal(pool.Bundles(big.NewInt(2)), bundles)
+}
+
+// orderedPrices inserts one tx per price from fresh users and returns the prices
+// in the order they're batched
+func orderedPrices(t *testing.T, ordering string, seed int64, prices ...int64) []int64 {
+	pool, err := NewLinkedPoolWithConfig(Config{Ordering: ordering, Seed: seed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, price := range prices {
+		usr, err := module.NewUser()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pool.Insert(usr.From, signedTx(t, usr, 0, price)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out []int64
+	for _, tx := range pool.Batch(1000000) {
+		out = append(out, tx.GasPrice().Int64())
+	}
+	return out
+}
+
+func TestOrderings(t *testing.T) {
+	is := is.New(t)
+	prices := []int64{20, 10, 30, 40, 15}
+	is.Equal(orderedPrices(t, OrderPrice, 0, prices...), []int64{40, 30, 20, 15, 10})
+	is.Equal(orderedPrices(t, OrderFIFO, 0, prices...), prices)
+	is.Equal(orderedPrices(t, OrderReverse, 0, prices...), []int64{10, 15, 20, 30, 40})
+
+	// the same seed reproduces the same order
+	random := orderedPrices(t, OrderRandom, 42, prices...)
+	is.Equal(len(random), len(prices))
+	is.Equal(orderedPrices(t, OrderRandom, 42, prices...), random)
+
+	_, err := NewLinkedPoolWithConfig(Config{Ordering: "sideways"})
+	is.True(err != nil)
+}
+
+// nonceOrdering releases the sender with the lowest nonce first
+type nonceOrdering struct{}
+
+func (nonceOrdering) Less(a, b TxInfo) bool {
+	return a.Nonce > b.Nonce
+}
+
+func TestCustomOrdering(t *testing.T) {
+	is := is.New(t)
+	pool := NewLinkedPool()
+	for i := uint64(3); i > 0; i-- {
+		usr, err := module.NewUser()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool.SetNonceFunc(func(common.Address) uint64 { return i })
+		is.NoErr(pool.Insert(usr.From, signedTx(t, usr, i, 10)))
+	}
+	pool.SetOrdering(nonceOrdering{})
+	var nonces []uint64
+	for _, tx := range pool.Batch(1000000) {
+		nonces = append(nonces, tx.Nonce())
+	}
+	is.Equal(nonces, []uint64{1, 2, 3})
+}
+
+func TestRestoreKeepsArrival(t *testing.T) {
+	is := is.New(t)
+	alice, err := module.NewUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := module.NewUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := NewLinkedPoolWithConfig(Config{Ordering: OrderFIFO})
+	is.NoErr(err)
+	is.NoErr(pool.Insert(alice.From, signedTx(t, alice, 0, 10), signedTx(t, alice, 1, 10)))
+	is.NoErr(pool.Insert(bob.From, signedTx(t, bob, 0, 10)))
+
+	// alice's second tx doesn't fit, but it arrived before bob's, so it's still first
+	txs := pool.Batch(21000)
+	is.Equal(len(txs), 1)
+	txs = pool.Batch(1000000)
+	is.Equal(len(txs), 2)
+	signer := types.NewEIP155Signer(big.NewInt(1))
+	from, err := signer.Sender(txs[0])
+	is.NoErr(err)
+	is.Equal(from, alice.From)
+	is.Equal(txs[0].Nonce(), uint64(1))
+}
+
+func TestRestorePulledKeepsArrival(t *testing.T) {
+	is := is.New(t)
+	var users []*module.User
+	for i := 0; i < 3; i++ {
+		usr, err := module.NewUser()
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, usr)
+	}
+	pool, err := NewLinkedPoolWithConfig(Config{Ordering: OrderFIFO})
+	is.NoErr(err)
+	var txs []*types.Transaction
+	for _, usr := range users {
+		tx := signedTx(t, usr, 0, 10)
+		is.NoErr(pool.Insert(usr.From, tx))
+		txs = append(txs, tx)
+	}
+
+	// taken and batched txs go back in the place they arrived in, not at the front
+	is.True(pool.Take(txs[1:2]))
+	pool.Restore(txs[1:2])
+	batched := pool.Batch(1000000)
+	is.Equal(len(batched), 3)
+	pool.Restore(batched[1:])
+	batched = append(batched[:1], pool.Batch(1000000)...)
+	for i, tx := range batched {
+		is.Equal(tx.Hash(), txs[i].Hash())
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	is := is.New(t)
+	usr, err := module.NewUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewLinkedPool()
+	pool.SetNonceFunc(func(common.Address) uint64 { return 0 })
+
+	first, second := signedTx(t, usr, 0, 10), signedTx(t, usr, 1, 10)
+	is.NoErr(pool.Insert(usr.From, first, second))
+	future := signedTx(t, usr, 5, 10)
+	is.NoErr(pool.Insert(usr.From, future))
+
+	snap := pool.Snapshot()
+	pending, queued := snap.Len()
+	is.Equal(pending, 2)
+	is.Equal(queued, 1)
+
+	linked := snap.Pending[usr.From][1]
+	is.Equal(linked.Tx.Hash(), second.Hash())
+	is.Equal(linked.Set, first.Hash())
+	is.Equal(linked.Index, 1)
+	is.True(linked.Linked())
+	is.True(!snap.Queued[usr.From][5].Linked())
+
+	_, isPending, has := snap.Lookup(future.Hash())
+	is.True(has)
+	is.True(!isPending)
+
+	// the snapshot doesn't change with the pool
+	pool.Batch(1000000)
+	pending, _ = snap.Len()
+	is.Equal(pending, 2)
+}